@@ -0,0 +1,192 @@
+package main
+
+import (
+	"audit"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求结束后写入的审计记录
+const auditKey = "audit_entries"
+
+// 管理员请求中标识管理员的请求头,也可以使用manager_id参数
+const managerIDHeader = "X-Manager-ID"
+
+// 写入审计记录的超时时间
+const auditTimeout = 5 * time.Second
+
+// detail列为TEXT类型,最多64KB
+const auditMaxDetail = 65535
+
+// 详情中最多列出的文件数
+const auditMaxPaths = 100
+
+// 登记一条审计记录,在请求结束后连同IP与请求结果一起写入
+//
+// 返回的记录可以继续补充详情,或单独设置结果
+func auditEvent(ctx *gin.Context, action, actor, target, detail string) *audit.Entry {
+	e := &audit.Entry{
+		Action: action,
+		Actor:  actor,
+		Target: target,
+		Detail: detail,
+	}
+	ctx.Set(auditKey, append(auditEntries(ctx), e))
+	return e
+}
+
+// 文件列表的审计详情,记录文件数,文件过多时只列出前auditMaxPaths个
+func pathsDetail(paths []string) string {
+	detail := "count=" + strconv.Itoa(len(paths)) + " files="
+	if len(paths) > auditMaxPaths {
+		return detail + strings.Join(paths[:auditMaxPaths], ",") + ",..."
+	}
+	return detail + strings.Join(paths, ",")
+}
+
+func auditEntries(ctx *gin.Context) []*audit.Entry {
+	v, _ := ctx.Get(auditKey)
+	list, _ := v.([]*audit.Entry)
+	return list
+}
+
+// 请求结束后写入登记的审计记录,/manager下的请求没有登记时也会记录
+func auditTrail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		list := auditEntries(ctx)
+		// 新旧接口记录为相同的操作
+		if route := strings.TrimPrefix(ctx.FullPath(), "/v2"); len(list) == 0 && strings.HasPrefix(route, "/manager/") {
+			actor := ctx.GetHeader(managerIDHeader)
+			if len(actor) == 0 {
+				actor = ctx.Query("manager_id")
+			}
+			list = append(list, &audit.Entry{
+				Action: "manager." + strings.ReplaceAll(strings.TrimPrefix(route, "/manager/"), "/", "."),
+				Actor:  actor,
+				Detail: ctx.Request.URL.RawQuery,
+			})
+		}
+
+		outcome, reason := audit.OutcomeSuccess, ""
+		if v, ok := ctx.Get(failReasonKey); ok {
+			outcome, reason = audit.OutcomeFail, v.(string)
+		} else if ctx.Writer.Status() >= http.StatusBadRequest {
+			outcome, reason = audit.OutcomeFail, http.StatusText(ctx.Writer.Status())
+		}
+		if len(list) == 0 {
+			return
+		}
+		// 客户端断开后请求的context会被取消,审计记录仍然需要写入
+		wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx.Request.Context()), auditTimeout)
+		defer cancel()
+		wdb := db.WithContext(wctx)
+		for _, e := range list {
+			if len(e.Outcome) == 0 {
+				e.Outcome, e.Reason = outcome, reason
+			}
+			if len(e.Reason) > 255 {
+				e.Reason = strings.ToValidUTF8(e.Reason[:255], "")
+			}
+			if len(e.Detail) > auditMaxDetail {
+				e.Detail = strings.ToValidUTF8(e.Detail[:auditMaxDetail], "")
+			}
+			e.IP = ctx.ClientIP()
+			e.RequestID = ctx.Writer.Header().Get(requestIDHeader)
+			if err := audit.Record(wdb, e); err != nil {
+				reqLog(ctx).Error("write audit log failed", "action", e.Action, "err", err)
+			}
+		}
+	}
+}
+
+// 管理员查询审计记录
+//
+// 参数:action, actor, target, outcome(success/fail/rolled_back), after, before, offset, limit,
+// format为jsonl时按时间顺序导出所有满足条件的记录,每行一条Json,忽略offset与limit
+//
+// 返回:Json{"status", "reason", "total", "entries"}
+func ManagerAuditHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		f := audit.Filter{
+			Action:  ctx.Query("action"),
+			Actor:   ctx.Query("actor"),
+			Target:  ctx.Query("target"),
+			Outcome: ctx.Query("outcome"),
+		}
+		var err error
+		if f.After, err = queryTime(ctx, "after"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if f.Before, err = queryTime(ctx, "before"); err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		if ctx.Query("format") == "jsonl" {
+			ctx.Header("Content-Type", "application/x-ndjson")
+			ctx.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+			ctx.Status(http.StatusOK)
+			enc := json.NewEncoder(ctx.Writer)
+			err = audit.Export(reqDB(ctx), f, func(e *audit.Entry) error {
+				return enc.Encode(e)
+			})
+			if err != nil {
+				// 响应已经开始发送,只能记录错误
+				reqLog(ctx).Error("export audit log failed", "err", err)
+			}
+			return
+		}
+
+		if s := ctx.Query("offset"); len(s) > 0 {
+			if f.Offset, err = strconv.Atoi(s); err != nil || f.Offset < 0 {
+				fail(ctx, invalidParam("invalid offset"))
+				return
+			}
+		}
+		if f.Limit, err = queryLimit(ctx, 100, 1000); err != nil {
+			fail(ctx, err)
+			return
+		}
+		entries, total, err := audit.Query(reqDB(ctx), f)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"total":   total,
+			"entries": entries,
+		})
+	}
+}
+
+// 管理员校验审计记录的哈希链
+//
+// 返回:Json{"status", "reason", "checked", "broken_id"},broken_id为第一条被删除或修改的记录
+func ManagerAuditVerifyHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		checked, broken, err := audit.Verify(reqDB(ctx))
+		if err != nil && err != audit.ErrBrokenChain {
+			fail(ctx, err)
+			return
+		}
+		status := "success"
+		if broken != 0 {
+			status = "fail"
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":    status,
+			"checked":   checked,
+			"broken_id": broken,
+		})
+	}
+}
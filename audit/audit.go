@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 操作结果
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFail       = "fail"
+	OutcomeRolledBack = "rolled_back"
+)
+
+// 一条审计记录,只追加不修改
+//
+// 每条记录的Hash包含上一条记录的Hash,删除或修改中间的记录会破坏哈希链
+type Entry struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"time"`
+	// 操作,如user.login、file.delete、manager.delete
+	Action string `gorm:"column:action;size:64;index" json:"action"`
+	// 执行操作的用户或管理员
+	Actor string `gorm:"column:actor;size:64;index" json:"actor"`
+	// 操作对象,文件路径或用户id
+	Target    string `gorm:"column:target;size:255;index" json:"target"`
+	Detail    string `gorm:"column:detail;type:text" json:"detail,omitempty"`
+	Outcome   string `gorm:"column:outcome;size:16" json:"outcome"`
+	Reason    string `gorm:"column:reason;size:255" json:"reason,omitempty"`
+	IP        string `gorm:"column:ip;size:64" json:"ip"`
+	RequestID string `gorm:"column:request_id;size:64" json:"request_id"`
+	PrevHash  string `gorm:"column:prev_hash;size:64" json:"prev_hash"`
+	Hash      string `gorm:"column:hash;size:64" json:"hash"`
+}
+
+func (Entry) TableName() string {
+	return "audit_log"
+}
+
+// 计算记录的哈希,包含上一条记录的哈希
+func (e *Entry) digest() string {
+	data, _ := json.Marshal([]interface{}{
+		e.PrevHash, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.Action, e.Actor, e.Target,
+		e.Detail, e.Outcome, e.Reason, e.IP, e.RequestID,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 写入记录时串行计算哈希链
+var (
+	chainLock sync.Mutex
+	lastHash  string
+)
+
+// 迁移审计表并读取哈希链的末尾
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		return err
+	}
+	var last Entry
+	err := db.Order("id DESC").Limit(1).Find(&last).Error
+	lastHash = last.Hash
+	return err
+}
+
+// 追加一条审计记录
+func Record(db *gorm.DB, e *Entry) error {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	e.ID = 0
+	// 数据库只保存到毫秒,截断后哈希才能在读出时重新计算
+	e.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	e.PrevHash = lastHash
+	e.Hash = e.digest()
+	if err := db.Create(e).Error; err != nil {
+		return err
+	}
+	lastHash = e.Hash
+	return nil
+}
+
+// 审计记录的查询条件
+type Filter struct {
+	Action  string
+	Actor   string
+	Target  string
+	Outcome string
+	After   *time.Time
+	Before  *time.Time
+	Offset  int
+	Limit   int
+}
+
+func (f Filter) apply(db *gorm.DB) *gorm.DB {
+	tx := db.Model(&Entry{})
+	if len(f.Action) > 0 {
+		tx = tx.Where("action = ?", f.Action)
+	}
+	if len(f.Actor) > 0 {
+		tx = tx.Where("actor = ?", f.Actor)
+	}
+	if len(f.Target) > 0 {
+		tx = tx.Where("target = ?", f.Target)
+	}
+	if len(f.Outcome) > 0 {
+		tx = tx.Where("outcome = ?", f.Outcome)
+	}
+	if f.After != nil {
+		tx = tx.Where("created_at >= ?", *f.After)
+	}
+	if f.Before != nil {
+		tx = tx.Where("created_at < ?", *f.Before)
+	}
+	return tx
+}
+
+// 按条件查询审计记录,按时间倒序,返回当前页与总数
+func Query(db *gorm.DB, f Filter) ([]Entry, int64, error) {
+	var total int64
+	if err := f.apply(db).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	entries := make([]Entry, 0)
+	err := f.apply(db).Order("id DESC").Offset(f.Offset).Limit(f.Limit).Find(&entries).Error
+	return entries, total, err
+}
+
+// 按时间顺序逐批读取满足条件的所有记录,用于导出
+func Export(db *gorm.DB, f Filter, fn func(*Entry) error) error {
+	var entries []Entry
+	var err error
+	res := f.apply(db).Order("id").FindInBatches(&entries, 500, func(tx *gorm.DB, batch int) error {
+		for i := range entries {
+			if err = fn(&entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Error
+}
+
+// 哈希链校验失败
+var ErrBrokenChain = errors.New("audit chain broken")
+
+// 校验整条哈希链,返回检查的记录数,以及第一条不一致的记录id
+func Verify(db *gorm.DB) (int, uint, error) {
+	count := 0
+	prev := ""
+	var broken uint
+	var entries []Entry
+	res := db.Model(&Entry{}).Order("id").FindInBatches(&entries, 500, func(tx *gorm.DB, batch int) error {
+		for i := range entries {
+			e := &entries[i]
+			count++
+			if e.PrevHash != prev || e.digest() != e.Hash {
+				broken = e.ID
+				return ErrBrokenChain
+			}
+			prev = e.Hash
+		}
+		return nil
+	})
+	if broken != 0 {
+		return count, broken, ErrBrokenChain
+	}
+	return count, 0, res.Error
+}
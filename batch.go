@@ -0,0 +1,311 @@
+package main
+
+import (
+	"file"
+	"fmt"
+	"logger"
+	"net/http"
+	"strings"
+	"user"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 批量操作类型
+const (
+	batchDelete  = "delete"
+	batchMove    = "move"
+	batchCopy    = "copy"
+	batchShare   = "share"
+	batchUnshare = "unshare"
+)
+
+// 单次批量请求最多的操作数
+const maxBatchOps = 1000
+
+type BatchOp struct {
+	Op   string `json:"op" binding:"required,oneof=delete move copy share unshare"`
+	Path string `json:"path" binding:"required,filepath"`
+	// move、copy的目标文件名,不含用户名
+	Dest string `json:"dest" binding:"max=1024"`
+	// share、unshare的用户,逗号分隔;unshare为空时取消全部分享
+	Target string `json:"target" binding:"max=4096"`
+}
+
+type BatchMsg struct {
+	UserID string `json:"user_id" binding:"required,max=64"`
+	// 为true时所有操作要么全部成功,要么全部回滚
+	Atomic bool      `json:"atomic"`
+	Ops    []BatchOp `json:"ops" binding:"dive"`
+}
+
+// 单个操作的结果,status为success、fail、rolled_back或skipped
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Dest   string `json:"dest,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// 批量操作中的文件,orig为内存中的文件,cur为执行到当前操作后的状态
+type batchFile struct {
+	orig *file.File
+	cur  file.File
+}
+
+// 批量操作的执行状态
+//
+// 内存中的文件在操作成功后才通过apply更新,事务模式下提交后才更新;
+// 执行期间用files记录本批次的修改,使后面的操作能看到前面操作的结果
+type batch struct {
+	u   *user.User
+	db  *gorm.DB
+	ctl *file.FileController
+	// 本批次修改过的文件,值为nil表示已删除或移走
+	files map[string]*batchFile
+	// 尚未计入用户用量的配额变化
+	charge int64
+	// 更新内存的函数
+	apply []func()
+	// 回滚时撤销磁盘修改的函数
+	undo []func()
+}
+
+func (b *batch) lookup(path string) *batchFile {
+	if bf, ok := b.files[path]; ok {
+		return bf
+	}
+	if f := fileMap[path]; f != nil {
+		return &batchFile{orig: f, cur: *f}
+	}
+	return nil
+}
+
+// 执行单个操作,返回目标路径
+func (b *batch) run(op BatchOp) (string, error) {
+	bf := b.lookup(op.Path)
+	if bf == nil {
+		return "", file.ErrFileNotExist
+	}
+	uid := b.u.GetId()
+	orig, cur := bf.orig, bf.cur
+
+	var dest string
+	if op.Op == batchMove || op.Op == batchCopy {
+		name, err := file.CleanName(op.Dest)
+		if err != nil {
+			return "", err
+		}
+		if dest = uid + "/" + name; b.lookup(dest) != nil {
+			return dest, file.ErrFileExisted
+		}
+	}
+
+	switch op.Op {
+	case batchDelete:
+		staged, err := b.ctl.StageDelete(cur, uid, b.db)
+		if err != nil {
+			return "", err
+		}
+		b.files[op.Path] = nil
+		b.charge -= cur.Charge()
+		b.undo = append(b.undo, func() { file.RestoreBlob(&cur, staged) })
+		b.apply = append(b.apply, func() {
+			forgetFile(orig)
+			if err := file.DropStaged(staged); err != nil {
+				logger.FromDB(b.db).Warn("remove staged blob failed", "staged", staged, "err", err)
+			}
+		})
+	case batchMove:
+		moved, err := b.ctl.MoveFile(cur, uid, strings.TrimPrefix(dest, uid+"/"), b.db)
+		if err != nil {
+			return dest, err
+		}
+		b.files[op.Path] = nil
+		b.files[dest] = &batchFile{orig: orig, cur: moved}
+		b.undo = append(b.undo, func() { file.RestoreBlob(&cur, moved.StoragePath()) })
+		b.apply = append(b.apply, func() { replaceFile(orig, moved) })
+	case batchCopy:
+		if b.u.GetDisk()-b.u.GetUseddisk()-b.charge < cur.GetConsume() {
+			return dest, file.ErrNoSpace
+		}
+		nf, err := b.ctl.CopyFile(cur, uid, strings.TrimPrefix(dest, uid+"/"), b.db)
+		if err != nil {
+			return dest, err
+		}
+		b.files[dest] = &batchFile{orig: nf, cur: *nf}
+		b.charge += nf.Charge()
+		b.undo = append(b.undo, func() { file.DeleteBlob(nf.Path) })
+		b.apply = append(b.apply, func() { rememberFile(b.u, nf) })
+	case batchShare, batchUnshare:
+		if cur.Uploader != uid {
+			return "", file.ErrNotOwner
+		}
+		target := shareTarget(b.u, cur.GetTarget(), op)
+		if err := b.ctl.UpdateTarget(&cur, strings.Join(target, ","), b.db); err != nil {
+			return "", err
+		}
+		b.files[op.Path] = &batchFile{orig: orig, cur: cur}
+		b.apply = append(b.apply, func() { replaceFile(orig, cur) })
+	default:
+		return "", invalidParam("unknown op %q", op.Op)
+	}
+	return dest, nil
+}
+
+// 计算share或unshare后的分享目标,share只添加好友
+func shareTarget(u *user.User, old []string, op BatchOp) []string {
+	res := make([]string, 0, len(old))
+	if op.Op == batchShare {
+		res = append(res, old...)
+		for _, t := range friendTargets(u, op.Target) {
+			if !contains(res, t) {
+				res = append(res, t)
+			}
+		}
+		return res
+	}
+	if len(op.Target) == 0 {
+		return res
+	}
+	remove := strings.Split(op.Target, ",")
+	for _, t := range old {
+		if !contains(remove, t) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 依次执行批量操作,调用者需持有fileLock
+//
+// 非事务模式下每个操作独立生效;事务模式下在一个数据库事务中执行,
+// 任一操作失败时回滚数据库并撤销已做的磁盘修改
+func runBatch(sess *gorm.DB, u *user.User, msg BatchMsg) ([]BatchResult, error) {
+	ctl := &file.FileController{}
+	ctl.SetSrv(file.FileServiceImpl{})
+	b := &batch{u: u, db: sess, ctl: ctl, files: make(map[string]*batchFile)}
+	results := make([]BatchResult, len(msg.Ops))
+	for i, op := range msg.Ops {
+		results[i] = BatchResult{Index: i, Op: op.Op, Path: op.Path, Status: "skipped"}
+	}
+
+	if !msg.Atomic {
+		for i, op := range msg.Ops {
+			dest, err := b.run(op)
+			results[i].Dest = dest
+			if err != nil {
+				results[i].Status, results[i].Reason = "fail", err.Error()
+				continue
+			}
+			results[i].Status = "success"
+			for _, fn := range b.apply {
+				fn()
+			}
+			b.apply, b.undo, b.charge = nil, nil, 0
+		}
+		return results, nil
+	}
+
+	err := sess.Transaction(func(tx *gorm.DB) error {
+		b.db = tx
+		for i, op := range msg.Ops {
+			dest, err := b.run(op)
+			results[i].Dest = dest
+			if err != nil {
+				results[i].Status, results[i].Reason = "fail", err.Error()
+				return fmt.Errorf("op %d: %w", i, err)
+			}
+			results[i].Status = "success"
+		}
+		return nil
+	})
+	if err != nil {
+		for i := len(b.undo) - 1; i >= 0; i-- {
+			b.undo[i]()
+		}
+		for i := range results {
+			if results[i].Status == "success" {
+				results[i].Status = "rolled_back"
+			}
+		}
+		return results, err
+	}
+	for _, fn := range b.apply {
+		fn()
+	}
+	return results, nil
+}
+
+// 批量删除、移动、复制、分享或取消分享文件
+//
+// 输入:Json{"user_id", "atomic", "ops": [{"op", "path", "dest", "target"}]},
+// op为delete、move、copy、share或unshare;move、copy的dest为目标文件名,share、unshare的target为逗号分隔的用户
+//
+// 返回:Json{"status", "reason", "succeeded", "failed", "results"},
+// 新接口中部分操作失败时HTTP状态码为207,事务模式下整批回滚时返回错误对应的状态码
+func FileBatchHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg BatchMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID)
+		if len(msg.Ops) == 0 || len(msg.Ops) > maxBatchOps {
+			fail(ctx, invalidParam("ops must contain 1 to %d operations", maxBatchOps))
+			return
+		}
+
+		userLock.Lock()
+		u := userMap[msg.UserID]
+		userLock.Unlock()
+		if u == nil {
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+
+		// 整批操作只获取一次锁
+		fileLock.Lock()
+		results, err := runBatch(reqDB(ctx), u, msg)
+		fileLock.Unlock()
+
+		count := map[string]int{}
+		for _, r := range results {
+			count[r.Status]++
+			if r.Status == "skipped" {
+				continue
+			}
+			detail := ""
+			if len(r.Dest) > 0 {
+				detail = "dest=" + r.Dest
+			} else if r.Op == batchShare || r.Op == batchUnshare {
+				detail = "target=" + msg.Ops[r.Index].Target
+			}
+			e := auditEvent(ctx, "file."+r.Op, msg.UserID, r.Path, detail)
+			e.Outcome, e.Reason = r.Status, r.Reason
+		}
+		status, res := http.StatusOK, gin.H{"status": "success"}
+		if err != nil {
+			// 事务模式下整批回滚
+			status, res = failResponse(ctx, err)
+		}
+		res["succeeded"], res["failed"], res["results"] = count["success"], count["fail"], results
+		if isV2(ctx) {
+			status = resultStatus(status, count["success"], count["fail"])
+		}
+		ctx.JSON(status, res)
+	}
+}
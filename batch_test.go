@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"file"
+	"fmt"
+	"testing"
+)
+
+func TestRunBatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		atomic bool
+		ops    []BatchOp
+		failOn string
+		err    error
+		status []string
+		// 批量操作后内存中的文件及其磁盘内容,""表示文件不存在
+		files map[string]string
+	}{
+		{
+			name:   "atomic success",
+			atomic: true,
+			ops: []BatchOp{
+				{Op: batchMove, Path: "alice/a.txt", Dest: "m.txt"},
+				{Op: batchDelete, Path: "alice/b.txt"},
+				{Op: batchCopy, Path: "alice/m.txt", Dest: "a.txt"},
+			},
+			status: []string{"success", "success", "success"},
+			files:  map[string]string{"alice/a.txt": "aaa", "alice/m.txt": "aaa", "alice/b.txt": "", "alice/c.txt": "ccc"},
+		},
+		{
+			name:   "atomic rollback on missing file",
+			atomic: true,
+			ops: []BatchOp{
+				{Op: batchMove, Path: "alice/a.txt", Dest: "m.txt"},
+				{Op: batchDelete, Path: "alice/b.txt"},
+				{Op: batchDelete, Path: "alice/missing.txt"},
+				{Op: batchDelete, Path: "alice/c.txt"},
+			},
+			err:    file.ErrFileNotExist,
+			status: []string{"rolled_back", "rolled_back", "fail", "skipped"},
+			files:  map[string]string{"alice/a.txt": "aaa", "alice/b.txt": "bbb", "alice/c.txt": "ccc", "alice/m.txt": ""},
+		},
+		{
+			name:   "atomic rollback on db failure",
+			atomic: true,
+			ops: []BatchOp{
+				{Op: batchMove, Path: "alice/a.txt", Dest: "m.txt"},
+				{Op: batchCopy, Path: "alice/b.txt", Dest: "d.txt"},
+				{Op: batchDelete, Path: "alice/c.txt"},
+			},
+			failOn: "UPDATE `files` SET `deleted_at`",
+			err:    errFakeDB,
+			status: []string{"rolled_back", "rolled_back", "fail"},
+			files:  map[string]string{"alice/a.txt": "aaa", "alice/b.txt": "bbb", "alice/c.txt": "ccc", "alice/m.txt": "", "alice/d.txt": ""},
+		},
+		{
+			name:   "atomic rollback on existing destination",
+			atomic: true,
+			ops: []BatchOp{
+				{Op: batchDelete, Path: "alice/b.txt"},
+				{Op: batchMove, Path: "alice/a.txt", Dest: "c.txt"},
+			},
+			err:    file.ErrFileExisted,
+			status: []string{"rolled_back", "fail"},
+			files:  map[string]string{"alice/a.txt": "aaa", "alice/b.txt": "bbb", "alice/c.txt": "ccc"},
+		},
+		{
+			name: "independent ops",
+			ops: []BatchOp{
+				{Op: batchMove, Path: "alice/a.txt", Dest: "m.txt"},
+				{Op: batchDelete, Path: "alice/missing.txt"},
+				{Op: batchDelete, Path: "alice/b.txt"},
+			},
+			status: []string{"success", "fail", "success"},
+			files:  map[string]string{"alice/a.txt": "", "alice/m.txt": "aaa", "alice/b.txt": "", "alice/c.txt": "ccc"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := useTestServer(t)
+			alice := addTestUser("alice", 1<<20)
+			for i, name := range []string{"a", "b", "c"} {
+				addTestFile(t, alice, uint(i+1), name+".txt", name+name+name)
+			}
+			fake.failOn = c.failOn
+
+			results, err := runBatch(db, alice, BatchMsg{UserID: "alice", Atomic: c.atomic, Ops: c.ops})
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			status := make([]string, len(results))
+			for i, r := range results {
+				status[i] = r.Status
+			}
+			if fmt.Sprint(status) != fmt.Sprint(c.status) {
+				t.Fatalf("status = %v, want %v", status, c.status)
+			}
+
+			var want int64
+			for path, content := range c.files {
+				f := fileMap[path]
+				if len(content) == 0 {
+					if f != nil {
+						t.Fatalf("%v still in memory", path)
+					}
+					if blob := blobContent(file.StorageRoot + "/" + path); len(blob) > 0 {
+						t.Fatalf("%v still on disk", path)
+					}
+					continue
+				}
+				if f == nil {
+					t.Fatalf("%v not in memory", path)
+				}
+				if blob := blobContent(f.StoragePath()); blob != content {
+					t.Fatalf("%v content = %q, want %q", path, blob, content)
+				}
+				want += int64(len(content))
+			}
+			if alice.GetUseddisk() != want || alice.GetFilenum() != len(fileOwnerMap["alice"]) {
+				t.Fatalf("usage = %v files, %v bytes, want %v bytes", alice.GetFilenum(), alice.GetUseddisk(), want)
+			}
+			if c.atomic && (fake.count("COMMIT") == 1) != (c.err == nil) {
+				t.Fatalf("statements = %v", fake.stmts)
+			}
+		})
+	}
+}
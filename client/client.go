@@ -0,0 +1,650 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 网盘服务的客户端
+//
+// 请求/v2下的新版接口,失败时返回*Error;健康检查、指标与接口文档请求根路径
+type Client struct {
+	// 服务地址,如http://127.0.0.1:8080
+	BaseURL string
+	// 为nil时使用http.DefaultClient
+	HTTPClient *http.Client
+	// 管理员接口的X-Manager-ID请求头,写入审计日志
+	ManagerID string
+	// 错误信息的语言,zh或en,为空时由服务决定
+	Lang string
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// 新版接口的错误响应
+type Error struct {
+	// HTTP状态码
+	StatusCode int          `json:"-"`
+	Status     string       `json:"status"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Detail     string       `json:"detail"`
+	Fields     []FieldError `json:"fields"`
+	TraceID    string       `json:"trace_id"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if len(e.Detail) > 0 {
+		msg += ": " + e.Detail
+	}
+	if len(e.Code) == 0 {
+		return fmt.Sprintf("http %d: %v", e.StatusCode, msg)
+	}
+	return fmt.Sprintf("%v (%v): %v", e.Code, e.StatusCode, msg)
+}
+
+// 接口,ID与openapi.json中的operationId对应
+type Operation struct {
+	ID     string
+	Method string
+	// 路径参数写作{name}
+	Path string
+	// 为true时只在根路径下提供,不在/v2下
+	Root bool
+	// 查询参数的结构体,字段通过query标签对应参数名;没有查询参数时为nil
+	Query any
+}
+
+var (
+	opHealthz       = Operation{ID: "healthz", Method: http.MethodGet, Path: "/healthz", Root: true}
+	opReadyz        = Operation{ID: "readyz", Method: http.MethodGet, Path: "/readyz", Root: true}
+	opMetrics       = Operation{ID: "metrics", Method: http.MethodGet, Path: "/metrics", Root: true}
+	opOpenAPI       = Operation{ID: "openapi", Method: http.MethodGet, Path: "/openapi.json", Root: true}
+	opRegister      = Operation{ID: "registerUser", Method: http.MethodPost, Path: "/user/register"}
+	opLogin         = Operation{ID: "login", Method: http.MethodPost, Path: "/user/login"}
+	opListUsers     = Operation{ID: "listUsers", Method: http.MethodGet, Path: "/user/list"}
+	opListFiles     = Operation{ID: "listFiles", Method: http.MethodGet, Path: "/user/files", Query: ListFilesParams{}}
+	opUserFiles     = Operation{ID: "userFiles", Method: http.MethodGet, Path: "/user/files/{user_id}"}
+	opFriends       = Operation{ID: "friends", Method: http.MethodGet, Path: "/user/friends/{user_id}"}
+	opAddFriend     = Operation{ID: "addFriend", Method: http.MethodPost, Path: "/user/update/friend"}
+	opDeleteUsers   = Operation{ID: "deleteUsers", Method: http.MethodPost, Path: "/manager/delete"}
+	opQueryUsers    = Operation{ID: "queryUsers", Method: http.MethodPost, Path: "/manager/query"}
+	opStats         = Operation{ID: "stats", Method: http.MethodGet, Path: "/manager/stats", Query: StatsParams{}}
+	opReconcile     = Operation{ID: "reconcile", Method: http.MethodPost, Path: "/manager/reconcile"}
+	opGC            = Operation{ID: "gc", Method: http.MethodPost, Path: "/manager/gc"}
+	opScrub         = Operation{ID: "scrub", Method: http.MethodPost, Path: "/manager/scrub"}
+	opCorruptFiles  = Operation{ID: "corruptFiles", Method: http.MethodGet, Path: "/manager/corrupt"}
+	opRotateKey     = Operation{ID: "rotateKey", Method: http.MethodPost, Path: "/manager/keys/rotate"}
+	opAudit         = Operation{ID: "audit", Method: http.MethodGet, Path: "/manager/audit", Query: AuditParams{}}
+	opAuditVerify   = Operation{ID: "auditVerify", Method: http.MethodGet, Path: "/manager/audit/verify"}
+	opDebugInfo     = Operation{ID: "debugInfo", Method: http.MethodGet, Path: "/manager/debug/info"}
+	opUpload        = Operation{ID: "upload", Method: http.MethodPost, Path: "/file/upload/{user}/{path}"}
+	opUploadArchive = Operation{ID: "uploadArchive", Method: http.MethodPost, Path: "/file/upload-archive/{user}", Query: UploadArchiveParams{}}
+	opBatch         = Operation{ID: "batch", Method: http.MethodPost, Path: "/file/batch"}
+	opCopy          = Operation{ID: "copy", Method: http.MethodPost, Path: "/file/copy"}
+	opMove          = Operation{ID: "move", Method: http.MethodPost, Path: "/file/move"}
+	opShare         = Operation{ID: "share", Method: http.MethodPost, Path: "/file/target"}
+	opFileOwners    = Operation{ID: "fileOwners", Method: http.MethodGet, Path: "/file/owner"}
+	opSearch        = Operation{ID: "search", Method: http.MethodGet, Path: "/file/search", Query: SearchParams{}}
+	opFullText      = Operation{ID: "fullText", Method: http.MethodGet, Path: "/file/fulltext", Query: FullTextParams{}}
+	opTags          = Operation{ID: "tags", Method: http.MethodPost, Path: "/file/tags"}
+	opDownload      = Operation{ID: "download", Method: http.MethodPost, Path: "/file/download"}
+	opThumbnail     = Operation{ID: "thumbnail", Method: http.MethodGet, Path: "/file/thumbnail", Query: ThumbnailParams{}}
+	opPreview       = Operation{ID: "preview", Method: http.MethodGet, Path: "/file/preview", Query: PreviewParams{}}
+	opArchive       = Operation{ID: "archive", Method: http.MethodPost, Path: "/file/archive"}
+	opDeleteFile    = Operation{ID: "deleteFile", Method: http.MethodPost, Path: "/file/delete"}
+)
+
+// 客户端实现的所有接口,服务端的测试会检查与openapi.json是否一致
+var Operations = []Operation{
+	opHealthz, opReadyz, opMetrics, opOpenAPI,
+	opRegister, opLogin, opListUsers, opListFiles, opUserFiles, opFriends, opAddFriend,
+	opDeleteUsers, opQueryUsers, opStats, opReconcile, opGC, opScrub, opCorruptFiles, opRotateKey,
+	opAudit, opAuditVerify, opDebugInfo,
+	opUpload, opUploadArchive, opBatch, opCopy, opMove, opShare, opFileOwners, opSearch, opFullText,
+	opTags, opDownload, opThumbnail, opPreview, opArchive, opDeleteFile,
+}
+
+type ListFilesParams struct {
+	UserID   string `query:"user_id"`
+	Scope    string `query:"scope"`
+	Uploader string `query:"uploader"`
+	Ext      string `query:"ext"`
+	Category string `query:"category"`
+	MinSize  *int64 `query:"min_size"`
+	MaxSize  *int64 `query:"max_size"`
+	Sort     string `query:"sort"`
+	Order    string `query:"order"`
+	// 上一页返回的NextCursor
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+}
+
+type StatsParams struct {
+	Days int `query:"days"`
+	Top  int `query:"top"`
+}
+
+type AuditParams struct {
+	Action  string     `query:"action"`
+	Actor   string     `query:"actor"`
+	Target  string     `query:"target"`
+	Outcome string     `query:"outcome"`
+	After   *time.Time `query:"after"`
+	Before  *time.Time `query:"before"`
+	Offset  int        `query:"offset"`
+	Limit   int        `query:"limit"`
+	// 由Audit与ExportAudit设置
+	Format string `query:"format"`
+}
+
+type UploadArchiveParams struct {
+	// 解压到的目录
+	Prefix string `query:"prefix"`
+	// zip、tar或tar.gz,为空时根据文件头判断
+	Format string `query:"format"`
+}
+
+type SearchParams struct {
+	UserID   string `query:"user_id"`
+	Name     string `query:"name"`
+	Match    string `query:"match"`
+	Uploader string `query:"uploader"`
+	Category string `query:"category"`
+	// 逗号分隔的标签
+	Tags    string     `query:"tags"`
+	MinSize *int64     `query:"min_size"`
+	MaxSize *int64     `query:"max_size"`
+	After   *time.Time `query:"after"`
+	Before  *time.Time `query:"before"`
+	Offset  int        `query:"offset"`
+	Limit   int        `query:"limit"`
+}
+
+type FullTextParams struct {
+	UserID string `query:"user_id"`
+	Q      string `query:"q"`
+	Limit  int    `query:"limit"`
+}
+
+type ThumbnailParams struct {
+	UserID string `query:"user_id"`
+	Path   string `query:"path"`
+	// small、medium或large,默认medium
+	Size string `query:"size"`
+}
+
+type PreviewParams struct {
+	UserID string `query:"user_id"`
+	Path   string `query:"path"`
+	// 读取的KB数,默认64
+	Limit int `query:"limit"`
+}
+
+// 将查询参数结构体转换为url.Values,零值字段不发送
+func encodeQuery(params any) url.Values {
+	q := url.Values{}
+	if params == nil {
+		return q
+	}
+	v := reflect.ValueOf(params)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, f := t.Field(i).Tag.Get("query"), v.Field(i)
+		if len(name) == 0 || f.IsZero() {
+			continue
+		}
+		if f.Kind() == reflect.Pointer {
+			f = f.Elem()
+		}
+		switch x := f.Interface().(type) {
+		case time.Time:
+			q.Set(name, x.Format(time.RFC3339))
+		case string:
+			q.Set(name, x)
+		case int:
+			q.Set(name, strconv.Itoa(x))
+		case int64:
+			q.Set(name, strconv.FormatInt(x, 10))
+		}
+	}
+	return q
+}
+
+// 生成请求,pathArgs依次替换路径中的参数
+func (c *Client) newRequest(ctx context.Context, op Operation, pathArgs []string, query any, body io.Reader) (*http.Request, error) {
+	segs := strings.Split(op.Path, "/")
+	for i, s := range segs {
+		if !strings.HasPrefix(s, "{") {
+			continue
+		}
+		if len(pathArgs) == 0 {
+			return nil, fmt.Errorf("%v: missing path parameter %v", op.ID, s)
+		}
+		segs[i], pathArgs = url.PathEscape(pathArgs[0]), pathArgs[1:]
+	}
+	u := c.BaseURL
+	if !op.Root {
+		u += "/v2"
+	}
+	u += strings.Join(segs, "/")
+	q := encodeQuery(query)
+	if len(c.Lang) > 0 && !op.Root {
+		q.Set("lang", c.Lang)
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, op.Method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.ManagerID) > 0 && strings.HasPrefix(op.Path, "/manager/") {
+		req.Header.Set("X-Manager-ID", c.ManagerID)
+	}
+	return req, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// 发送请求,状态码不是2xx时关闭响应并返回*Error
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return parseError(resp.StatusCode, body)
+}
+
+func parseError(status int, body []byte) error {
+	e := &Error{StatusCode: status}
+	if json.Unmarshal(body, e) != nil || len(e.Code) == 0 {
+		e.Message = strings.TrimSpace(string(body))
+		if len(e.Message) == 0 {
+			e.Message = http.StatusText(status)
+		}
+	}
+	return e
+}
+
+// 发送请求,in不为nil时作为Json请求体
+func (c *Client) request(ctx context.Context, op Operation, pathArgs []string, query, in any) (*http.Response, error) {
+	req, err := c.jsonRequest(ctx, op, pathArgs, query, in)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(req)
+}
+
+// 生成请求,in不为nil时作为Json请求体
+func (c *Client) jsonRequest(ctx context.Context, op Operation, pathArgs []string, query, in any) (*http.Request, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := c.newRequest(ctx, op, pathArgs, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// 发送返回各项结果的批量请求,将Json响应解码到out
+//
+// 部分失败时状态码为207,不作为错误;整体失败时返回*Error,out中仍为失败响应中的各项结果
+func (c *Client) sendResults(req *http.Request, out any) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+		json.Unmarshal(body, out)
+		return parseError(resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 发送请求并将Json响应解码到out
+func (c *Client) call(ctx context.Context, op Operation, pathArgs []string, query, in, out any) error {
+	resp, err := c.request(ctx, op, pathArgs, query, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 发送请求并返回响应体,调用者需关闭
+func (c *Client) stream(ctx context.Context, op Operation, query, in any) (io.ReadCloser, error) {
+	resp, err := c.request(ctx, op, nil, query, in)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// 进程存活检查
+func (c *Client) Healthz(ctx context.Context) (*HealthResponse, error) {
+	var res HealthResponse
+	return &res, c.call(ctx, opHealthz, nil, nil, nil, &res)
+}
+
+// 就绪检查,未就绪时返回的Status为fail,Checks中为各项检查的错误
+func (c *Client) Ready(ctx context.Context) (*ReadyResponse, error) {
+	req, err := c.newRequest(ctx, opReadyz, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, decodeError(resp)
+	}
+	var res ReadyResponse
+	return &res, json.NewDecoder(resp.Body).Decode(&res)
+}
+
+// 获取Prometheus格式的指标
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	body, err := c.stream(ctx, opMetrics, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	return string(b), err
+}
+
+// 获取OpenAPI文档
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	body, err := c.stream(ctx, opOpenAPI, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// 用户注册
+func (c *Client) Register(ctx context.Context, msg RegisterMsg) (*UserIDResponse, error) {
+	var res UserIDResponse
+	return &res, c.call(ctx, opRegister, nil, nil, msg, &res)
+}
+
+// 用户登录
+func (c *Client) Login(ctx context.Context, msg RawUser) (*UserIDResponse, error) {
+	var res UserIDResponse
+	return &res, c.call(ctx, opLogin, nil, nil, msg, &res)
+}
+
+// 获取已注册用户信息,调试用
+func (c *Client) ListUsers(ctx context.Context) (map[string]json.RawMessage, error) {
+	var res map[string]json.RawMessage
+	return res, c.call(ctx, opListUsers, nil, nil, nil, &res)
+}
+
+// 分页获取用户可以下载的文件列表
+func (c *Client) ListFiles(ctx context.Context, params ListFilesParams) (*FileListResponse, error) {
+	var res FileListResponse
+	return &res, c.call(ctx, opListFiles, nil, params, nil, &res)
+}
+
+// 获取用户可以下载的文件列表
+func (c *Client) UserFiles(ctx context.Context, userID string) (*UserFilesResponse, error) {
+	var res UserFilesResponse
+	return &res, c.call(ctx, opUserFiles, []string{userID}, nil, nil, &res)
+}
+
+// 获取用户的好友
+func (c *Client) Friends(ctx context.Context, userID string) (*FriendsResponse, error) {
+	var res FriendsResponse
+	return &res, c.call(ctx, opFriends, []string{userID}, nil, nil, &res)
+}
+
+// 用户添加好友
+func (c *Client) AddFriend(ctx context.Context, msg FriendMsg) (*StatusResponse, error) {
+	var res StatusResponse
+	return &res, c.call(ctx, opAddFriend, nil, nil, msg, &res)
+}
+
+// 管理员删除用户
+func (c *Client) DeleteUsers(ctx context.Context, msg DeleteMsg) (*StatusResponse, error) {
+	var res StatusResponse
+	return &res, c.call(ctx, opDeleteUsers, nil, nil, msg, &res)
+}
+
+// 管理员查找用户
+func (c *Client) QueryUsers(ctx context.Context, msg QueryMsg) (*QueryResponse, error) {
+	msg.Format = "json"
+	var res QueryResponse
+	return &res, c.call(ctx, opQueryUsers, nil, nil, msg, &res)
+}
+
+// 管理员以CSV格式导出用户,调用者需关闭返回的响应体
+func (c *Client) ExportUsers(ctx context.Context, msg QueryMsg) (io.ReadCloser, error) {
+	msg.Format = "csv"
+	return c.stream(ctx, opQueryUsers, nil, msg)
+}
+
+// 管理员查看存储使用统计
+func (c *Client) Stats(ctx context.Context, params StatsParams) (*StatsResponse, error) {
+	var res StatsResponse
+	return &res, c.call(ctx, opStats, nil, params, nil, &res)
+}
+
+// 管理员核对并修复用户用量
+func (c *Client) Reconcile(ctx context.Context, msg ReconcileMsg) (*ReconcileResponse, error) {
+	var res ReconcileResponse
+	return &res, c.call(ctx, opReconcile, nil, nil, msg, &res)
+}
+
+// 管理员回收孤儿文件与悬空记录
+func (c *Client) GC(ctx context.Context, msg GCMsg) (*GCResponse, error) {
+	var res GCResponse
+	return &res, c.call(ctx, opGC, nil, nil, msg, &res)
+}
+
+// 管理员立即校验所有文件
+func (c *Client) Scrub(ctx context.Context) (*ScrubResponse, error) {
+	var res ScrubResponse
+	return &res, c.call(ctx, opScrub, nil, nil, nil, &res)
+}
+
+// 管理员查看已标记损坏的文件
+func (c *Client) CorruptFiles(ctx context.Context) (*CorruptResponse, error) {
+	var res CorruptResponse
+	return &res, c.call(ctx, opCorruptFiles, nil, nil, nil, &res)
+}
+
+// 管理员轮换主密钥
+func (c *Client) RotateKey(ctx context.Context, msg RotateKeyMsg) (*RotateKeyResponse, error) {
+	var res RotateKeyResponse
+	return &res, c.call(ctx, opRotateKey, nil, nil, msg, &res)
+}
+
+// 管理员查询审计记录
+func (c *Client) Audit(ctx context.Context, params AuditParams) (*AuditResponse, error) {
+	params.Format = ""
+	var res AuditResponse
+	return &res, c.call(ctx, opAudit, nil, params, nil, &res)
+}
+
+// 管理员以JSON Lines格式导出审计记录,忽略Offset与Limit,调用者需关闭返回的响应体
+func (c *Client) ExportAudit(ctx context.Context, params AuditParams) (io.ReadCloser, error) {
+	params.Format = "jsonl"
+	return c.stream(ctx, opAudit, params, nil)
+}
+
+// 管理员校验审计记录的哈希链,哈希链被破坏时Status为fail
+func (c *Client) VerifyAudit(ctx context.Context) (*AuditVerifyResponse, error) {
+	var res AuditVerifyResponse
+	return &res, c.call(ctx, opAuditVerify, nil, nil, nil, &res)
+}
+
+// 管理员查看运行信息
+func (c *Client) DebugInfo(ctx context.Context) (*DebugInfoResponse, error) {
+	var res DebugInfoResponse
+	return &res, c.call(ctx, opDebugInfo, nil, nil, nil, &res)
+}
+
+// 上传文件,size为内容的字节数
+func (c *Client) Upload(ctx context.Context, userID, name string, r io.Reader, size int64) (*StatusResponse, error) {
+	return upload(c, ctx, opUpload, []string{userID, name}, nil, r, size, &StatusResponse{})
+}
+
+// 上传并解压压缩包,已保存的文件保留
+//
+// 部分条目未保存时Failed大于0;解压中途出错时Status为fail,已保存文件时不返回错误,
+// 没有保存任何文件时同时返回*Error与各条目的结果
+func (c *Client) UploadArchive(ctx context.Context, userID string, params UploadArchiveParams, r io.Reader, size int64) (*UploadArchiveResponse, error) {
+	req, err := c.uploadRequest(ctx, opUploadArchive, []string{userID}, params, r, size)
+	if err != nil {
+		return nil, err
+	}
+	var res UploadArchiveResponse
+	return &res, c.sendResults(req, &res)
+}
+
+// 生成上传请求
+func (c *Client) uploadRequest(ctx context.Context, op Operation, pathArgs []string, query any, r io.Reader, size int64) (*http.Request, error) {
+	req, err := c.newRequest(ctx, op, pathArgs, query, r)
+	if err != nil {
+		return nil, err
+	}
+	// 服务按Content-Length分配空间,不接受分块上传
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return req, nil
+}
+
+// 上传请求体,out为Json响应
+func upload[T any](c *Client, ctx context.Context, op Operation, pathArgs []string, query any, r io.Reader, size int64, out *T) (*T, error) {
+	req, err := c.uploadRequest(ctx, op, pathArgs, query, r, size)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return out, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 批量操作,部分操作失败时Failed大于0;事务模式下整批失败时同时返回*Error与各操作的结果
+func (c *Client) Batch(ctx context.Context, msg BatchMsg) (*BatchResponse, error) {
+	req, err := c.jsonRequest(ctx, opBatch, nil, nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	var res BatchResponse
+	return &res, c.sendResults(req, &res)
+}
+
+// 复制文件到用户自己的目录,返回新文件的路径
+func (c *Client) Copy(ctx context.Context, msg CopyMsg) (*PathResponse, error) {
+	var res PathResponse
+	return &res, c.call(ctx, opCopy, nil, nil, msg, &res)
+}
+
+// 移动或重命名自己的文件,返回新文件的路径
+func (c *Client) Move(ctx context.Context, msg CopyMsg) (*PathResponse, error) {
+	var res PathResponse
+	return &res, c.call(ctx, opMove, nil, nil, msg, &res)
+}
+
+// 更新文件的分享目标
+func (c *Client) Share(ctx context.Context, msg TargetMsg) (*StatusResponse, error) {
+	var res StatusResponse
+	return &res, c.call(ctx, opShare, nil, nil, msg, &res)
+}
+
+// 获取所有用户可下载的文件,调试用
+func (c *Client) FileOwners(ctx context.Context) (*FileOwnerResponse, error) {
+	var res FileOwnerResponse
+	return &res, c.call(ctx, opFileOwners, nil, nil, nil, &res)
+}
+
+// 搜索用户可以下载的文件
+func (c *Client) Search(ctx context.Context, params SearchParams) (*SearchResponse, error) {
+	var res SearchResponse
+	return &res, c.call(ctx, opSearch, nil, params, nil, &res)
+}
+
+// 全文搜索用户可以下载的文本文件
+func (c *Client) FullText(ctx context.Context, params FullTextParams) (*FullTextResponse, error) {
+	var res FullTextResponse
+	return &res, c.call(ctx, opFullText, nil, params, nil, &res)
+}
+
+// 更新文件标签
+func (c *Client) Tags(ctx context.Context, msg TagMsg) (*TagsResponse, error) {
+	var res TagsResponse
+	return &res, c.call(ctx, opTags, nil, nil, msg, &res)
+}
+
+// 下载文件,调用者需关闭返回的响应体
+func (c *Client) Download(ctx context.Context, userID, path string) (io.ReadCloser, error) {
+	return c.stream(ctx, opDownload, nil, TargetMsg{UserID: userID, Path: path})
+}
+
+// 获取JPEG格式的缩略图,调用者需关闭返回的响应体
+func (c *Client) Thumbnail(ctx context.Context, params ThumbnailParams) (io.ReadCloser, error) {
+	return c.stream(ctx, opThumbnail, params, nil)
+}
+
+// 预览文本文件
+func (c *Client) Preview(ctx context.Context, params PreviewParams) (*PreviewResponse, error) {
+	var res PreviewResponse
+	return &res, c.call(ctx, opPreview, nil, params, nil, &res)
+}
+
+// 打包下载多个文件,调用者需关闭返回的响应体
+func (c *Client) Archive(ctx context.Context, msg ArchiveMsg) (io.ReadCloser, error) {
+	return c.stream(ctx, opArchive, nil, msg)
+}
+
+// 删除上传的文件
+func (c *Client) DeleteFile(ctx context.Context, userID, path string) (*StatusResponse, error) {
+	var res StatusResponse
+	return &res, c.call(ctx, opDeleteFile, nil, nil, TargetMsg{UserID: userID, Path: path}, &res)
+}
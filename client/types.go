@@ -0,0 +1,485 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 与openapi.json中components.schemas同名的类型,服务端的测试会检查两者的字段是否一致
+var Schemas = map[string]any{
+	"Error":                 Error{},
+	"FieldError":            FieldError{},
+	"StatusResponse":        StatusResponse{},
+	"UserIDResponse":        UserIDResponse{},
+	"PathResponse":          PathResponse{},
+	"RawUser":               RawUser{},
+	"RegisterMsg":           RegisterMsg{},
+	"FriendMsg":             FriendMsg{},
+	"DeleteMsg":             DeleteMsg{},
+	"TargetMsg":             TargetMsg{},
+	"CopyMsg":               CopyMsg{},
+	"ArchiveMsg":            ArchiveMsg{},
+	"TagMsg":                TagMsg{},
+	"QueryMsg":              QueryMsg{},
+	"ReconcileMsg":          ReconcileMsg{},
+	"GCMsg":                 GCMsg{},
+	"RotateKeyMsg":          RotateKeyMsg{},
+	"BatchOp":               BatchOp{},
+	"BatchMsg":              BatchMsg{},
+	"SimpleFile":            SimpleFile{},
+	"FileInfo":              FileInfo{},
+	"UserView":              UserView{},
+	"UserFilesResponse":     UserFilesResponse{},
+	"FileListResponse":      FileListResponse{},
+	"FriendsResponse":       FriendsResponse{},
+	"QueryResponse":         QueryResponse{},
+	"SizeBucket":            SizeBucket{},
+	"TypeCount":             TypeCount{},
+	"DailyActivity":         DailyActivity{},
+	"StatsResponse":         StatsResponse{},
+	"Discrepancy":           Discrepancy{},
+	"ReconcileResponse":     ReconcileResponse{},
+	"OrphanBlob":            OrphanBlob{},
+	"DanglingRecord":        DanglingRecord{},
+	"GCReport":              GCReport{},
+	"GCResponse":            GCResponse{},
+	"ScrubReport":           ScrubReport{},
+	"ScrubResponse":         ScrubResponse{},
+	"CorruptFile":           CorruptFile{},
+	"CorruptResponse":       CorruptResponse{},
+	"RotateKeyResponse":     RotateKeyResponse{},
+	"AuditEntry":            AuditEntry{},
+	"AuditResponse":         AuditResponse{},
+	"AuditVerifyResponse":   AuditVerifyResponse{},
+	"DebugInfoResponse":     DebugInfoResponse{},
+	"HealthResponse":        HealthResponse{},
+	"ReadyResponse":         ReadyResponse{},
+	"ExtractResult":         ExtractResult{},
+	"UploadArchiveResponse": UploadArchiveResponse{},
+	"SearchResponse":        SearchResponse{},
+	"SearchHit":             SearchHit{},
+	"FullTextResponse":      FullTextResponse{},
+	"TagsResponse":          TagsResponse{},
+	"Preview":               Preview{},
+	"PreviewResponse":       PreviewResponse{},
+	"BatchResult":           BatchResult{},
+	"BatchResponse":         BatchResponse{},
+	"FileOwnerResponse":     FileOwnerResponse{},
+}
+
+// 请求体中单个字段的校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type StatusResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type UserIDResponse struct {
+	Status string `json:"status"`
+	UserID string `json:"user_id"`
+}
+
+type PathResponse struct {
+	Status string `json:"status"`
+	Path   string `json:"path"`
+}
+
+type RawUser struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+}
+
+type RegisterMsg struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+	Disk     int64  `json:"disk"`
+}
+
+type FriendMsg struct {
+	Me     string `json:"me"`
+	Friend string `json:"friend"`
+}
+
+type DeleteMsg struct {
+	// 逗号分隔的用户
+	UserID    string `json:"user_id"`
+	ManagerID string `json:"manager_id,omitempty"`
+}
+
+type TargetMsg struct {
+	UserID string `json:"user_id"`
+	// 逗号分隔的用户
+	Target string `json:"target,omitempty"`
+	Path   string `json:"path"`
+}
+
+type CopyMsg struct {
+	UserID string `json:"user_id"`
+	Path   string `json:"path"`
+	Dest   string `json:"dest,omitempty"`
+}
+
+type ArchiveMsg struct {
+	UserID string   `json:"user_id"`
+	Paths  []string `json:"paths,omitempty"`
+	Folder string   `json:"folder,omitempty"`
+	Format string   `json:"format,omitempty"`
+}
+
+type TagMsg struct {
+	UserID string `json:"user_id"`
+	Path   string `json:"path"`
+	Tags   string `json:"tags"`
+}
+
+type QueryMsg struct {
+	UserID        string     `json:"user_id,omitempty"`
+	MinUsed       *int64     `json:"min_used,omitempty"`
+	MaxUsed       *int64     `json:"max_used,omitempty"`
+	MinDisk       *int64     `json:"min_disk,omitempty"`
+	MaxDisk       *int64     `json:"max_disk,omitempty"`
+	MinFileNum    *int       `json:"min_file_num,omitempty"`
+	MaxFileNum    *int       `json:"max_file_num,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	LoginAfter    *time.Time `json:"login_after,omitempty"`
+	LoginBefore   *time.Time `json:"login_before,omitempty"`
+	Sort          string     `json:"sort,omitempty"`
+	Order         string     `json:"order,omitempty"`
+	Offset        int        `json:"offset,omitempty"`
+	Limit         int        `json:"limit,omitempty"`
+	// 由QueryUsers与ExportUsers设置
+	Format string `json:"format,omitempty"`
+}
+
+type ReconcileMsg struct {
+	// 逗号分隔的用户,为空时核对所有用户
+	UserID string `json:"user_id,omitempty"`
+	Fix    bool   `json:"fix"`
+}
+
+type GCMsg struct {
+	Action string `json:"action,omitempty"`
+}
+
+type RotateKeyMsg struct {
+	Generate bool `json:"generate"`
+}
+
+type BatchOp struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Dest   string `json:"dest,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+type BatchMsg struct {
+	UserID string    `json:"user_id"`
+	Atomic bool      `json:"atomic"`
+	Ops    []BatchOp `json:"ops"`
+}
+
+type SimpleFile struct {
+	FilePath string   `json:"file_path"`
+	Uploader string   `json:"uploader"`
+	Target   []string `json:"target"`
+}
+
+type FileInfo struct {
+	FilePath  string    `json:"file_path"`
+	Name      string    `json:"name"`
+	Uploader  string    `json:"uploader"`
+	Target    []string  `json:"target"`
+	Tags      []string  `json:"tags"`
+	Size      int64     `json:"size"`
+	Mime      string    `json:"mime"`
+	Ext       string    `json:"ext"`
+	Category  string    `json:"category"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type UserView struct {
+	UserID    string     `json:"user_id"`
+	Friends   []string   `json:"friends"`
+	FileNum   int        `json:"file_num"`
+	DiskUsed  int64      `json:"disk_used"`
+	Disk      int64      `json:"disk"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastLogin *time.Time `json:"last_login"`
+}
+
+type UserFilesResponse struct {
+	MyFile    []SimpleFile `json:"my_file"`
+	OtherFile []SimpleFile `json:"other_file"`
+	FileNum   int          `json:"file_num"`
+	SpaceUsed string       `json:"space_used"`
+}
+
+type FileListResponse struct {
+	Status     string     `json:"status"`
+	Files      []FileInfo `json:"files"`
+	NextCursor string     `json:"next_cursor"`
+	FileNum    int        `json:"file_num"`
+	SpaceUsed  string     `json:"space_used"`
+}
+
+type FriendsResponse struct {
+	Status  string   `json:"status"`
+	Friends []string `json:"friends"`
+}
+
+type QueryResponse struct {
+	Status string     `json:"status"`
+	Total  int64      `json:"total"`
+	Users  []UserView `json:"users"`
+}
+
+type SizeBucket struct {
+	Label string `json:"label"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+type TypeCount struct {
+	Ext   string `json:"ext"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+type DailyActivity struct {
+	Day           string `json:"day"`
+	Uploads       int64  `json:"uploads"`
+	UploadBytes   int64  `json:"upload_bytes"`
+	Downloads     int64  `json:"downloads"`
+	DownloadBytes int64  `json:"download_bytes"`
+}
+
+type StatsResponse struct {
+	Status           string          `json:"status"`
+	TotalUsers       int64           `json:"total_users"`
+	TotalFiles       int64           `json:"total_files"`
+	BytesStored      int64           `json:"bytes_stored"`
+	BytesPhysical    int64           `json:"bytes_physical"`
+	BytesAllocated   int64           `json:"bytes_allocated"`
+	BytesUsed        int64           `json:"bytes_used"`
+	TopUsers         []UserView      `json:"top_users"`
+	SizeHistogram    []SizeBucket    `json:"size_histogram"`
+	TypeDistribution []TypeCount     `json:"type_distribution"`
+	DailyActivity    []DailyActivity `json:"daily_activity"`
+	DuplicateFiles   int64           `json:"duplicate_files"`
+	DedupSavings     int64           `json:"dedup_savings"`
+}
+
+type Discrepancy struct {
+	UserID        string   `json:"user_id"`
+	Filenum       int      `json:"file_num"`
+	Diskused      int64    `json:"disk_used"`
+	ActualFilenum int      `json:"actual_file_num"`
+	RecordedBytes int64    `json:"recorded_bytes"`
+	DiskBytes     int64    `json:"disk_bytes"`
+	StoredBytes   int64    `json:"stored_bytes"`
+	MissingFiles  []string `json:"missing_files"`
+	SizeMismatch  []string `json:"size_mismatch"`
+	Fixed         bool     `json:"fixed"`
+}
+
+type ReconcileResponse struct {
+	Status        string        `json:"status"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+type OrphanBlob struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	MovedTo   string    `json:"moved_to"`
+	Processed bool      `json:"processed"`
+}
+
+type DanglingRecord struct {
+	Path      string `json:"path"`
+	Uploader  string `json:"uploader"`
+	Size      int64  `json:"size"`
+	Processed bool   `json:"processed"`
+}
+
+type GCReport struct {
+	Action          string           `json:"action"`
+	OrphanBlobs     []OrphanBlob     `json:"orphan_blobs"`
+	DanglingRecords []DanglingRecord `json:"dangling_records"`
+	Errors          []string         `json:"errors"`
+}
+
+type GCResponse struct {
+	Status string   `json:"status"`
+	Report GCReport `json:"report"`
+}
+
+type ScrubReport struct {
+	Checked int      `json:"checked"`
+	Corrupt []string `json:"corrupt"`
+	Errors  []string `json:"errors"`
+}
+
+type ScrubResponse struct {
+	Status string      `json:"status"`
+	Report ScrubReport `json:"report"`
+}
+
+type CorruptFile struct {
+	FilePath  string     `json:"file_path"`
+	Uploader  string     `json:"uploader"`
+	SHA256    string     `json:"sha256"`
+	CheckedAt *time.Time `json:"checked_at"`
+}
+
+type CorruptResponse struct {
+	Status string        `json:"status"`
+	Files  []CorruptFile `json:"files"`
+}
+
+type RotateKeyResponse struct {
+	Status    string   `json:"status"`
+	Active    string   `json:"active"`
+	Rewrapped int      `json:"rewrapped"`
+	Errors    []string `json:"errors"`
+}
+
+type AuditEntry struct {
+	ID        uint      `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Target    string    `json:"target"`
+	Detail    string    `json:"detail"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason"`
+	IP        string    `json:"ip"`
+	RequestID string    `json:"request_id"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+type AuditResponse struct {
+	Status  string       `json:"status"`
+	Total   int64        `json:"total"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+type AuditVerifyResponse struct {
+	Status string `json:"status"`
+	// 已校验的记录数
+	Checked int `json:"checked"`
+	// 第一条被删除或修改的记录,哈希链完整时为0
+	BrokenID uint `json:"broken_id"`
+}
+
+type DebugInfoResponse struct {
+	Status     string           `json:"status"`
+	Version    string           `json:"version"`
+	GoVersion  string           `json:"go_version"`
+	Revision   string           `json:"revision"`
+	Uptime     string           `json:"uptime"`
+	Config     map[string]any   `json:"config"`
+	Cache      map[string]int64 `json:"cache"`
+	Goroutines int              `json:"goroutines"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+	Uptime string `json:"uptime"`
+}
+
+type ReadyResponse struct {
+	Status string `json:"status"`
+	// 各项检查的结果,通过时为ok,否则为错误信息
+	Checks map[string]string `json:"checks"`
+}
+
+type ExtractResult struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type UploadArchiveResponse struct {
+	Status  string          `json:"status"`
+	Reason  string          `json:"reason"`
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Detail  string          `json:"detail"`
+	Saved   int             `json:"saved"`
+	Failed  int             `json:"failed"`
+	Skipped int             `json:"skipped"`
+	Entries []ExtractResult `json:"entries"`
+}
+
+type SearchResponse struct {
+	Status string     `json:"status"`
+	Files  []FileInfo `json:"files"`
+}
+
+type SearchHit struct {
+	FilePath string `json:"file_path"`
+	Score    int    `json:"score"`
+	Snippet  string `json:"snippet"`
+}
+
+type FullTextResponse struct {
+	Status string      `json:"status"`
+	Files  []SearchHit `json:"files"`
+}
+
+type TagsResponse struct {
+	Status string   `json:"status"`
+	Tags   []string `json:"tags"`
+}
+
+type Preview struct {
+	Encoding  string `json:"encoding"`
+	Language  string `json:"language"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated"`
+	Content   string `json:"content"`
+	HTML      string `json:"html"`
+}
+
+type PreviewResponse struct {
+	Status  string  `json:"status"`
+	Preview Preview `json:"preview"`
+}
+
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Dest   string `json:"dest"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// 整批失败时Status为fail,Code为错误码;单个操作的结果见Results
+type BatchResponse struct {
+	Status    string        `json:"status"`
+	Reason    string        `json:"reason"`
+	Code      string        `json:"code"`
+	Message   string        `json:"message"`
+	Detail    string        `json:"detail"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+type FileOwnerResponse struct {
+	Status string                       `json:"status"`
+	Data   map[string][]json.RawMessage `json:"data"`
+}
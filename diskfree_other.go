@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// 不支持的平台上返回-1,跳过剩余空间检查
+func freeDisk(dir string) (int64, error) {
+	return -1, nil
+}
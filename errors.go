@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"file"
+	"fmt"
+	"io"
+	"net/http"
+	"user"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+)
+
+// 标记新版接口的键,新版接口返回对应的HTTP状态码、错误码与本地化的错误信息
+const apiVersionKey = "api_version"
+
+// 为新版接口的路由组设置标记
+func apiV2() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(apiVersionKey, 2)
+		ctx.Next()
+	}
+}
+
+func isV2(ctx *gin.Context) bool {
+	return ctx.GetInt(apiVersionKey) == 2
+}
+
+// 新版批量接口的HTTP状态码,status为整体的状态码
+//
+// 整体失败且没有任何一项生效时使用错误对应的状态码;有一项失败而其他项已生效时返回207
+func resultStatus(status, succeeded, failed int) int {
+	if status != http.StatusOK && succeeded == 0 {
+		return status
+	}
+	if status != http.StatusOK || failed > 0 {
+		return http.StatusMultiStatus
+	}
+	return http.StatusOK
+}
+
+// 请求参数不合法
+var errInvalidParam = errors.New("invalid parameter")
+
+// 请求参数不合法的错误,Error返回原有的失败原因
+type paramError string
+
+func (e paramError) Error() string {
+	return string(e)
+}
+
+func (e paramError) Unwrap() error {
+	return errInvalidParam
+}
+
+func invalidParam(format string, args ...any) error {
+	return paramError(fmt.Sprintf(format, args...))
+}
+
+// 旧接口保留原有的失败原因,新接口使用被包装的错误
+type legacyError struct {
+	err    error
+	reason string
+}
+
+func (e legacyError) Error() string {
+	return e.reason
+}
+
+func (e legacyError) Unwrap() error {
+	return e.err
+}
+
+func legacy(err error, reason string) error {
+	return legacyError{err: err, reason: reason}
+}
+
+// 错误对应的错误码、HTTP状态码与本地化信息
+type errorSpec struct {
+	err    error
+	code   string
+	status int
+	zh     string
+	en     string
+}
+
+// 按顺序匹配,具体的错误排在其类型之前
+var errorSpecs = []errorSpec{
+	{user.ErrUserNotExist, "USER_NOT_FOUND", http.StatusNotFound, "用户不存在", "user not found"},
+	{user.ErrUserExisted, "USER_EXISTS", http.StatusConflict, "用户已存在", "user already exists"},
+	{user.ErrInvalidCredentials, "INVALID_CREDENTIALS", http.StatusUnauthorized, "用户名或密码错误", "invalid user id or password"},
+	{user.ErrFriendExisted, "FRIEND_EXISTS", http.StatusConflict, "已经是好友", "already friends"},
+	{user.ErrFriendLimit, "FRIEND_LIMIT_EXCEEDED", http.StatusConflict, "好友数量已达上限", "friend limit exceeded"},
+	{user.ErrInvalid, "INVALID_ARGUMENT", http.StatusBadRequest, "参数不合法", "invalid argument"},
+	{file.ErrFileNotExist, "FILE_NOT_FOUND", http.StatusNotFound, "文件不存在", "file not found"},
+	{file.ErrFileExisted, "FILE_EXISTS", http.StatusConflict, "文件已存在", "file already exists"},
+	{file.ErrForbidden, "FORBIDDEN", http.StatusForbidden, "无权访问该文件", "permission denied"},
+	{file.ErrNoSpace, "QUOTA_EXCEEDED", http.StatusInsufficientStorage, "剩余空间不足", "not enough space"},
+	{file.ErrTooLarge, "PAYLOAD_TOO_LARGE", http.StatusRequestEntityTooLarge, "请求内容过大", "request body too large"},
+	{file.ErrChecksumMismatch, "CHECKSUM_MISMATCH", http.StatusUnprocessableEntity, "校验和不一致", "checksum mismatch"},
+	{file.ErrNotText, "NOT_TEXT", http.StatusUnsupportedMediaType, "文件不是文本", "file is not text"},
+	{file.ErrNoThumbnail, "THUMBNAIL_UNSUPPORTED", http.StatusUnsupportedMediaType, "该文件不支持缩略图", "thumbnail not supported for this file"},
+	{file.ErrNoMasterKey, "ENCRYPTION_UNAVAILABLE", http.StatusServiceUnavailable, "未配置主密钥", "master key not configured"},
+	{file.ErrInvalid, "INVALID_ARGUMENT", http.StatusBadRequest, "参数不合法", "invalid argument"},
+	{errInvalidParam, "INVALID_ARGUMENT", http.StatusBadRequest, "参数不合法", "invalid argument"},
+	{errBodyTooLarge, "PAYLOAD_TOO_LARGE", http.StatusRequestEntityTooLarge, "请求内容过大", "request body too large"},
+	{errLengthRequired, "LENGTH_REQUIRED", http.StatusLengthRequired, "缺少Content-Length", "content length required"},
+	{io.ErrUnexpectedEOF, "INCOMPLETE_BODY", http.StatusBadRequest, "请求内容不完整", "incomplete request body"},
+	{errScrubRunning, "OPERATION_IN_PROGRESS", http.StatusConflict, "已有相同的任务在运行", "operation already in progress"},
+	{gorm.ErrRecordNotFound, "NOT_FOUND", http.StatusNotFound, "记录不存在", "record not found"},
+}
+
+// 未匹配的错误
+var internalError = errorSpec{nil, "INTERNAL", http.StatusInternalServerError, "服务器内部错误", "internal server error"}
+
+func lookupError(err error) errorSpec {
+	for _, s := range errorSpecs {
+		if errors.Is(err, s.err) {
+			return s
+		}
+	}
+	return internalError
+}
+
+// 支持的语言,第一个为默认语言
+var languages = language.NewMatcher([]language.Tag{language.Chinese, language.English})
+
+// 根据lang参数或Accept-Language请求头选择错误信息的语言
+func localize(ctx *gin.Context, s errorSpec) string {
+	tag, _ := language.MatchStrings(languages, ctx.Query("lang"), ctx.GetHeader("Accept-Language"))
+	if base, _ := tag.Base(); base.String() == "en" {
+		return s.en
+	}
+	return s.zh
+}
+
+// 新版接口的错误详情,错误本身就是错误类型时没有详情,旧接口保留的失败原因不作为详情;
+// 内部错误可能包含SQL、文件路径等信息,不返回给客户端,通过日志与trace_id排查
+func errorDetail(err error, s errorSpec) string {
+	if s.code == internalError.code {
+		return ""
+	}
+	var le legacyError
+	if errors.As(err, &le) {
+		err = le.err
+	}
+	if err == s.err {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResultStatus(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		succeeded int
+		failed    int
+		want      int
+	}{
+		{"all succeeded", http.StatusOK, 3, 0, http.StatusOK},
+		{"some failed", http.StatusOK, 2, 1, http.StatusMultiStatus},
+		{"all failed individually", http.StatusOK, 0, 3, http.StatusMultiStatus},
+		{"rolled back", http.StatusConflict, 0, 1, http.StatusConflict},
+		{"failed after saving", http.StatusBadRequest, 2, 0, http.StatusMultiStatus},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := resultStatus(c.status, c.succeeded, c.failed); got != c.want {
+				t.Fatalf("resultStatus = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
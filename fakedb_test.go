@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"file"
+	"io"
+	"os"
+	"path/filepath"
+	"search"
+	"strings"
+	"sync"
+	"testing"
+	"user"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// 测试中模拟的数据库错误
+var errFakeDB = errors.New("fake db error")
+
+// 测试用的数据库,查询files表时返回files中的记录,其他语句只记录不执行
+type fakeDB struct {
+	mu    sync.Mutex
+	files []file.File
+	// 执行过的语句
+	stmts []string
+	// 语句包含该内容时返回errFakeDB
+	failOn string
+	nextID int64
+}
+
+func (d *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{d}, nil
+}
+
+func (d *fakeDB) Driver() driver.Driver {
+	return d
+}
+
+func (d *fakeDB) Open(string) (driver.Conn, error) {
+	return fakeConn{d}, nil
+}
+
+// 执行过的语句中包含s的数量
+func (d *fakeDB) count(s string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, stmt := range d.stmts {
+		if strings.Contains(stmt, s) {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *fakeDB) record(query string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stmts = append(d.stmts, query)
+	if len(d.failOn) > 0 && strings.Contains(query, d.failOn) {
+		return errFakeDB
+	}
+	return nil
+}
+
+type fakeConn struct {
+	d *fakeDB
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx(c), c.d.record("BEGIN")
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.d.record(query); err != nil {
+		return nil, err
+	}
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.nextID++
+	return fakeResult(c.d.nextID), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.d.record(query); err != nil {
+		return nil, err
+	}
+	rows := &fakeRows{}
+	if !strings.HasPrefix(query, "SELECT") || !strings.Contains(query, "FROM `files`") {
+		return rows, nil
+	}
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	for _, f := range c.d.files {
+		if strings.Contains(query, "file_uploader = ?") && len(args) > 0 && args[0].Value != f.Uploader {
+			continue
+		}
+		rows.files = append(rows.files, f)
+	}
+	return rows, nil
+}
+
+type fakeTx fakeConn
+
+func (tx fakeTx) Commit() error {
+	return tx.d.record("COMMIT")
+}
+
+func (tx fakeTx) Rollback() error {
+	return tx.d.record("ROLLBACK")
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// files表的查询结果
+type fakeRows struct {
+	files []file.File
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "created_at", "updated_at", "file_path", "file_name", "file_uploader", "share_target",
+		"file_consume", "storage_format", "stored_size", "file_ext"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.files) == 0 {
+		return io.EOF
+	}
+	f := r.files[0]
+	r.files = r.files[1:]
+	values := []driver.Value{int64(f.ID), f.CreatedAt, f.UpdatedAt, f.Path, f.Name, f.Uploader, f.Target,
+		f.Consume, f.Format, f.Stored, f.Ext}
+	copy(dest, values)
+	return nil
+}
+
+// 在临时目录中运行,使用测试数据库与空的内存状态
+func useTestServer(t *testing.T) *fakeDB {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeDB{nextID: 1000}
+	sqlDB := sql.OpenDB(fake)
+	gdb, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDB, oldIndexer := db, textIndexer
+	db, textIndexer = gdb, search.NewIndexer()
+	userMap = make(map[string]*user.User)
+	fileMap = make(map[string]*file.File)
+	fileOwnerMap = make(map[string][]*file.File)
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		sqlDB.Close()
+		db, textIndexer = oldDB, oldIndexer
+		userMap, fileMap, fileOwnerMap = nil, nil, nil
+	})
+	return fake
+}
+
+// 添加用户
+func addTestUser(id string, disk int64) *user.User {
+	u := &user.User{Id: id, Disk: disk}
+	u.ID = uint(len(userMap) + 1)
+	userMap[id] = u
+	return u
+}
+
+// 在磁盘上写入文件内容并加入内存,返回文件记录
+func addTestFile(t *testing.T, u *user.User, id uint, name, content string) *file.File {
+	t.Helper()
+	f := &file.File{Path: u.GetId() + "/" + name, Name: name, Uploader: u.GetId(),
+		Consume: int64(len(content)), Stored: int64(len(content))}
+	f.ID = id
+	if err := os.MkdirAll(filepath.Dir(f.StoragePath()), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.StoragePath(), []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fileMap[f.Path] = f
+	fileOwnerMap[f.Uploader] = append(fileOwnerMap[f.Uploader], f)
+	u.SetFilenum(u.GetFilenum() + 1)
+	u.SetUseddisk(u.GetUseddisk() + f.Charge())
+	return f
+}
+
+// 读取磁盘上的文件内容,不存在时返回空字符串
+func blobContent(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
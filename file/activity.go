@@ -0,0 +1,28 @@
+package file
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 活动类型
+const (
+	ActivityUpload   = "upload"
+	ActivityDownload = "download"
+)
+
+// 文件上传、下载记录,用于统计
+type Activity struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Kind      string    `gorm:"column:kind;size:16"`
+	UserID    string    `gorm:"column:user_id;size:64"`
+	Path      string    `gorm:"column:file_path;size:255"`
+	Bytes     int64     `gorm:"column:bytes"`
+}
+
+// 记录一次文件活动
+func RecordActivity(kind, userId, path string, bytes int64, db *gorm.DB) error {
+	return db.Create(&Activity{Kind: kind, UserID: userId, Path: path, Bytes: bytes}).Error
+}
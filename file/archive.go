@@ -0,0 +1,140 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 打包下载支持的格式
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// 逐个写入文件的打包器
+type archiveWriter interface {
+	add(f *File, c Content) error
+	addText(name, text string) error
+	Close() error
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(f *File, c Content) error {
+	method := zip.Deflate
+	if compressedExts[f.GetExt()] {
+		method = zip.Store
+	}
+	w, err := a.w.CreateHeader(&zip.FileHeader{Name: f.GetPath(), Method: method, Modified: f.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, c)
+	return err
+}
+
+func (a *zipArchive) addText(name, text string) error {
+	w, err := a.w.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, text)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	w  *tar.Writer
+}
+
+func (a *tarArchive) add(f *File, c Content) error {
+	err := a.w.WriteHeader(&tar.Header{Name: f.GetPath(), Mode: 0644, Size: c.Size(), ModTime: f.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.w, c)
+	return err
+}
+
+func (a *tarArchive) addText(name, text string) error {
+	err := a.w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(text))})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(a.w, text)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+func newArchiveWriter(w io.Writer, format string) (archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &zipArchive{w: zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchive{gz: gz, w: tar.NewWriter(gz)}, nil
+	}
+	return nil, ErrInvalidArchive
+}
+
+// 打包结果
+type ArchiveResult struct {
+	// 已写入的文件
+	Written []string
+	// 无权访问或读取失败而跳过的文件及原因
+	Skipped []string
+}
+
+// 将文件逐个流式写入压缩包,不在磁盘上暂存
+//
+// 每个文件使用与DownloadFile相同的权限检查,跳过的文件列在压缩包末尾的_skipped.txt中
+func writeArchive(w io.Writer, format string, files []File, userId string, skipped []string) (ArchiveResult, error) {
+	res := ArchiveResult{Written: make([]string, 0, len(files)), Skipped: skipped}
+	a, err := newArchiveWriter(w, format)
+	if err != nil {
+		return res, err
+	}
+
+	for i := range files {
+		f := &files[i]
+		if !f.Accessible(userId) {
+			res.Skipped = append(res.Skipped, f.GetPath()+": user is not target")
+			continue
+		}
+		c, err := OpenContent(f)
+		if err != nil {
+			res.Skipped = append(res.Skipped, fmt.Sprintf("%v: %v", f.GetPath(), err))
+			continue
+		}
+		err = a.add(f, c)
+		c.Close()
+		if err != nil {
+			// 写入中途失败时压缩包已经损坏,只能中止
+			return res, fmt.Errorf("%v when archiving %v", err, f.GetPath())
+		}
+		res.Written = append(res.Written, f.GetPath())
+	}
+
+	if len(res.Skipped) > 0 {
+		if err = a.addText("_skipped.txt", strings.Join(res.Skipped, "\n")+"\n"); err != nil {
+			return res, err
+		}
+	}
+	return res, a.Close()
+}
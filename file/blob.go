@@ -0,0 +1,319 @@
+package file
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 文件存储格式
+const (
+	// 原样存储的文件
+	FormatRaw = ""
+	// 分块存储的文件,每块可以单独加密,支持随机读取
+	FormatBlob = "blob"
+)
+
+// 分块存储格式:
+//
+//	header: magic(4) version(1) flags(1) chunkSize(4) plainSize(8) nonce(12)
+//	chunks: 每块按flags依次压缩、加密后的数据,压缩时块首字节标记该块是否压缩
+//	trailer: 每块长度(4*n) 块数(4)
+const (
+	blobMagic      = "SNDB"
+	blobVersion    = 1
+	blobHeaderSize = 30
+	blobChunkSize  = 64 << 10
+
+	blobEncrypted  = 1 << 0
+	blobCompressed = 1 << 1
+)
+
+// 压缩块的首字节
+const (
+	chunkStored = 0
+	chunkZstd   = 1
+)
+
+var errBlobFormat = newError(ErrCorrupt, "invalid blob format")
+
+type blobHeader struct {
+	flags     byte
+	chunkSize int64
+	size      int64
+	nonce     []byte
+}
+
+func (h *blobHeader) marshal() []byte {
+	buf := make([]byte, blobHeaderSize)
+	copy(buf, blobMagic)
+	buf[4] = blobVersion
+	buf[5] = h.flags
+	binary.BigEndian.PutUint32(buf[6:], uint32(h.chunkSize))
+	binary.BigEndian.PutUint64(buf[10:], uint64(h.size))
+	copy(buf[18:], h.nonce)
+	return buf
+}
+
+func parseBlobHeader(buf []byte) (*blobHeader, error) {
+	if len(buf) < blobHeaderSize || string(buf[:4]) != blobMagic || buf[4] != blobVersion {
+		return nil, errBlobFormat
+	}
+	h := &blobHeader{
+		flags:     buf[5],
+		chunkSize: int64(binary.BigEndian.Uint32(buf[6:])),
+		size:      int64(binary.BigEndian.Uint64(buf[10:])),
+		nonce:     append([]byte(nil), buf[18:blobHeaderSize]...),
+	}
+	if h.chunkSize <= 0 {
+		return nil, errBlobFormat
+	}
+	return h, nil
+}
+
+// 每块的nonce为基础nonce与块序号异或,附加数据为文件头与块序号
+func chunkNonce(base []byte, i int) []byte {
+	nonce := append([]byte(nil), base...)
+	var idx [8]byte
+	binary.BigEndian.PutUint64(idx[:], uint64(i))
+	for j := range idx {
+		nonce[len(nonce)-8+j] ^= idx[j]
+	}
+	return nonce
+}
+
+func chunkAAD(header []byte, i int) []byte {
+	return appendUint32(append([]byte(nil), header...), uint32(i))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// 块数量,空文件也保留一个空块
+func chunkCount(size, chunkSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 将r中size字节的数据写入分块存储格式,dataKey为nil时不加密,compress为true时每块单独压缩
+//
+// 按块读取,内存中只保留一块
+func writeBlob(w io.Writer, r io.Reader, size int64, dataKey []byte, compress bool) error {
+	h := &blobHeader{chunkSize: blobChunkSize, size: size, nonce: make([]byte, 12)}
+	if compress {
+		h.flags |= blobCompressed
+	}
+	var gcm cipher.AEAD
+	if dataKey != nil {
+		var err error
+		if gcm, err = newAEAD(dataKey); err != nil {
+			return err
+		}
+		if _, err = rand.Read(h.nonce); err != nil {
+			return err
+		}
+		h.flags |= blobEncrypted
+	}
+	header := h.marshal()
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	count := chunkCount(h.size, h.chunkSize)
+	lengths := make([]byte, 0, 4*count+4)
+	buf := make([]byte, min(h.size, h.chunkSize))
+	for i := 0; int64(i) < count; i++ {
+		chunk := buf[:min(h.size-int64(i)*h.chunkSize, h.chunkSize)]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+		if compress {
+			chunk = compressChunk(chunk)
+		}
+		if gcm != nil {
+			chunk = gcm.Seal(nil, chunkNonce(h.nonce, i), chunk, chunkAAD(header, i))
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		lengths = appendUint32(lengths, uint32(len(chunk)))
+	}
+	lengths = appendUint32(lengths, uint32(count))
+	_, err := w.Write(lengths)
+	return err
+}
+
+// 分块存储文件的读取器,按块解密,支持Seek与随机读取
+type blobReader struct {
+	fp      *os.File
+	header  []byte
+	h       *blobHeader
+	gcm     cipher.AEAD
+	offsets []int64
+	pos     int64
+	cached  int
+	buf     []byte
+}
+
+func openBlob(path string, dataKey []byte) (*blobReader, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newBlobReader(fp, dataKey)
+	if err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("%w when opening %v", err, path)
+	}
+	return r, nil
+}
+
+func newBlobReader(fp *os.File, dataKey []byte) (*blobReader, error) {
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, blobHeaderSize)
+	if _, err = fp.ReadAt(header, 0); err != nil {
+		return nil, errBlobFormat
+	}
+	h, err := parseBlobHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// 读取文件末尾的块长度表
+	var tail [4]byte
+	if _, err = fp.ReadAt(tail[:], info.Size()-4); err != nil {
+		return nil, errBlobFormat
+	}
+	count := int64(binary.BigEndian.Uint32(tail[:]))
+	if count != chunkCount(h.size, h.chunkSize) {
+		return nil, errBlobFormat
+	}
+	table := make([]byte, 4*count)
+	if _, err = fp.ReadAt(table, info.Size()-4-4*count); err != nil {
+		return nil, errBlobFormat
+	}
+	offsets := make([]int64, count+1)
+	offsets[0] = blobHeaderSize
+	for i := int64(0); i < count; i++ {
+		offsets[i+1] = offsets[i] + int64(binary.BigEndian.Uint32(table[4*i:]))
+	}
+	if offsets[count] != info.Size()-4-4*count {
+		return nil, errBlobFormat
+	}
+
+	r := &blobReader{fp: fp, header: header, h: h, offsets: offsets, cached: -1}
+	if h.flags&blobEncrypted != 0 {
+		if dataKey == nil {
+			return nil, errors.New("missing data key")
+		}
+		if r.gcm, err = newAEAD(dataKey); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// 读取并还原第i块
+func (r *blobReader) load(i int) error {
+	if r.cached == i {
+		return nil
+	}
+	chunk := make([]byte, r.offsets[i+1]-r.offsets[i])
+	if _, err := r.fp.ReadAt(chunk, r.offsets[i]); err != nil {
+		return err
+	}
+	if r.gcm != nil {
+		var err error
+		chunk, err = r.gcm.Open(chunk[:0], chunkNonce(r.h.nonce, i), chunk, chunkAAD(r.header, i))
+		if err != nil {
+			return newError(ErrCorrupt, fmt.Sprintf("chunk %v authentication failed", i))
+		}
+	}
+	if r.h.flags&blobCompressed != 0 {
+		var err error
+		if chunk, err = decompressChunk(chunk); err != nil {
+			return newError(ErrCorrupt, fmt.Sprintf("%v when decompressing chunk %v", err, i))
+		}
+	}
+	expect := r.h.size - int64(i)*r.h.chunkSize
+	if expect > r.h.chunkSize {
+		expect = r.h.chunkSize
+	}
+	if int64(len(chunk)) != expect {
+		return errBlobFormat
+	}
+	r.cached, r.buf = i, chunk
+	return nil
+}
+
+func (r *blobReader) Size() int64 {
+	return r.h.size
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *blobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) && off < r.h.size {
+		i := int(off / r.h.chunkSize)
+		if err := r.load(i); err != nil {
+			return n, err
+		}
+		c := copy(p[n:], r.buf[off-int64(i)*r.h.chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.h.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *blobReader) Close() error {
+	return r.fp.Close()
+}
@@ -0,0 +1,156 @@
+package file
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写入分块存储格式的临时文件并返回其路径
+func writeTestBlob(t *testing.T, data, dataKey []byte, compress bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blob")
+	var buf bytes.Buffer
+	if err := writeBlob(&buf, bytes.NewReader(data), int64(len(data)), dataKey, compress); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestBlobRoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("netdisk blob "), 3*blobChunkSize/13+5)
+	random := make([]byte, 2*blobChunkSize+17)
+	rand.Read(random)
+	key := testKey(t)
+
+	cases := []struct {
+		name     string
+		data     []byte
+		key      []byte
+		compress bool
+	}{
+		{"empty", nil, nil, false},
+		{"plain", text, nil, false},
+		{"compressed", text, nil, true},
+		{"compressed random", random, nil, true},
+		{"encrypted", random, key, false},
+		{"encrypted empty", nil, key, false},
+		{"compressed and encrypted", text, key, true},
+		{"one chunk", text[:blobChunkSize], key, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := openBlob(writeTestBlob(t, c.data, c.key, c.compress), c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if r.Size() != int64(len(c.data)) {
+				t.Fatalf("size = %v, want %v", r.Size(), len(c.data))
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, c.data) {
+				t.Fatal("content differs")
+			}
+
+			// 跨块的随机读取
+			if len(c.data) > blobChunkSize {
+				off := int64(blobChunkSize - 10)
+				buf := make([]byte, 30)
+				if _, err = r.ReadAt(buf, off); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf, c.data[off:off+30]) {
+					t.Fatal("ReadAt across chunks differs")
+				}
+			}
+		})
+	}
+}
+
+func TestBlobTamper(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), blobChunkSize/5)
+	key := testKey(t)
+
+	cases := []struct {
+		name   string
+		key    []byte
+		tamper func([]byte) []byte
+	}{
+		{"flip chunk byte", key, func(b []byte) []byte {
+			b[blobHeaderSize+100] ^= 1
+			return b
+		}},
+		{"flip last chunk byte", key, func(b []byte) []byte {
+			b[len(b)-4*3-1] ^= 1
+			return b
+		}},
+		// 文件头是每块的附加数据,修改后所有块认证失败
+		{"flip header size", key, func(b []byte) []byte {
+			b[17] ^= 1
+			return b
+		}},
+		{"flip nonce", key, func(b []byte) []byte {
+			b[20] ^= 1
+			return b
+		}},
+		{"bad magic", key, func(b []byte) []byte {
+			b[0] = 'X'
+			return b
+		}},
+		{"truncated", key, func(b []byte) []byte {
+			return b[:len(b)-10]
+		}},
+		{"bad chunk count", nil, func(b []byte) []byte {
+			b[len(b)-1]++
+			return b
+		}},
+		{"wrong key", testKey(t), func(b []byte) []byte {
+			return b
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			writeKey := key
+			if c.key == nil {
+				writeKey = nil
+			}
+			path := writeTestBlob(t, data, writeKey, false)
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.WriteFile(path, c.tamper(raw), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := openBlob(path, c.key)
+			if err == nil {
+				_, err = io.ReadAll(r)
+				r.Close()
+			}
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("err = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
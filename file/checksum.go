@@ -0,0 +1,186 @@
+package file
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 文件内容的校验和
+type Checksum struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// 计算数据的校验和
+func ComputeChecksum(data []byte) Checksum {
+	m := md5.Sum(data)
+	s := sha256.Sum256(data)
+	return Checksum{MD5: m[:], SHA256: s[:]}
+}
+
+// 计算流的校验和
+func ReadChecksum(r io.Reader) (Checksum, error) {
+	w := newChecksumWriter()
+	if _, err := io.Copy(w, r); err != nil {
+		return Checksum{}, err
+	}
+	return w.Sum(), nil
+}
+
+// 计算写入数据的校验和
+type checksumWriter struct {
+	md5, sha256 hash.Hash
+}
+
+func newChecksumWriter() *checksumWriter {
+	return &checksumWriter{md5: md5.New(), sha256: sha256.New()}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	w.md5.Write(p)
+	w.sha256.Write(p)
+	return len(p), nil
+}
+
+// 已写入数据的校验和
+func (w *checksumWriter) Sum() Checksum {
+	return Checksum{MD5: w.md5.Sum(nil), SHA256: w.sha256.Sum(nil)}
+}
+
+// 解析Digest/Content-Digest中的算法与值,如"sha-256=xxx, md5=yyy"或"sha-256=:xxx:"
+func parseDigest(header string) map[string]string {
+	res := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		res[strings.ToLower(kv[0])] = strings.Trim(kv[1], ":")
+	}
+	return res
+}
+
+// 校验请求头中客户端提供的校验和
+//
+// 支持Content-MD5、Digest、Content-Digest(sha-256/md5,base64)与X-Checksum-Sha256(hex),
+// 未提供时不做校验
+func VerifyChecksum(h http.Header, sum Checksum) error {
+	expect := func(name, encoded string, decode func(string) ([]byte, error), actual []byte) error {
+		v, err := decode(encoded)
+		if err != nil {
+			return invalid("invalid " + name)
+		}
+		if !bytes.Equal(v, actual) {
+			return newError(ErrChecksumMismatch, name+" mismatch")
+		}
+		return nil
+	}
+
+	if v := h.Get("Content-MD5"); len(v) > 0 {
+		if err := expect("Content-MD5", v, base64.StdEncoding.DecodeString, sum.MD5); err != nil {
+			return err
+		}
+	}
+	if v := h.Get("X-Checksum-Sha256"); len(v) > 0 {
+		if err := expect("X-Checksum-Sha256", v, hex.DecodeString, sum.SHA256); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{"Digest", "Content-Digest"} {
+		for alg, v := range parseDigest(h.Get(name)) {
+			var err error
+			switch alg {
+			case "sha-256":
+				err = expect(name+" sha-256", v, base64.StdEncoding.DecodeString, sum.SHA256)
+			case "md5":
+				err = expect(name+" md5", v, base64.StdEncoding.DecodeString, sum.MD5)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 下载时返回文件的校验和
+//
+// partial为true时响应可能只包含部分内容,不设置描述整个内容的Content-MD5与Digest
+func setChecksumHeaders(h http.Header, f *File, partial bool) {
+	if len(f.SHA256) == 0 {
+		return
+	}
+	sha, err := hex.DecodeString(f.SHA256)
+	if err != nil {
+		return
+	}
+	h.Set("ETag", `"`+f.SHA256+`"`)
+	h.Set("X-Checksum-Sha256", f.SHA256)
+	if partial {
+		return
+	}
+	digest := "sha-256=" + base64.StdEncoding.EncodeToString(sha)
+	if m, err := hex.DecodeString(f.MD5); err == nil && len(m) > 0 {
+		h.Set("Content-MD5", base64.StdEncoding.EncodeToString(m))
+		digest += ",md5=" + base64.StdEncoding.EncodeToString(m)
+	}
+	h.Set("Digest", digest)
+}
+
+// 校验结果
+type ScrubResult struct {
+	SHA256    string
+	MD5       string
+	Corrupt   bool
+	CheckedAt time.Time
+}
+
+// 重新计算磁盘上文件的校验和并与记录比对,结果写入数据库
+//
+// 没有记录校验和的旧文件会补全校验和;不一致时标记文件损坏,
+// 分块存储的文件头或块长度表不合法、加密块认证失败时同样标记损坏
+func scrub(f File, db *gorm.DB) (ScrubResult, error) {
+	res := ScrubResult{SHA256: f.SHA256, MD5: f.MD5, CheckedAt: time.Now()}
+	var sum Checksum
+	r, err := OpenContent(&f)
+	if err == nil {
+		sum, err = ReadChecksum(r)
+		r.Close()
+	}
+	switch {
+	case errors.Is(err, ErrCorrupt):
+		res.Corrupt = true
+	case err != nil:
+		return res, err
+	default:
+		actual := hex.EncodeToString(sum.SHA256)
+		if len(f.SHA256) == 0 {
+			res.SHA256, res.MD5 = actual, hex.EncodeToString(sum.MD5)
+		}
+		res.Corrupt = actual != res.SHA256
+	}
+	err = db.Model(&File{}).Where("id = ?", f.ID).Updates(map[string]interface{}{
+		"sha256":     res.SHA256,
+		"md5":        res.MD5,
+		"corrupt":    res.Corrupt,
+		"checked_at": res.CheckedAt,
+	}).Error
+	return res, err
+}
+
+// 更新内存中文件的校验结果
+func (f *File) ApplyScrub(res ScrubResult) {
+	f.SHA256, f.MD5 = res.SHA256, res.MD5
+	f.Corrupt, f.CheckedAt = res.Corrupt, &res.CheckedAt
+}
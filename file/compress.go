@@ -0,0 +1,71 @@
+package file
+
+import (
+	"errors"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 是否压缩可压缩的文件
+var compression bool
+
+// 是否按压缩、加密后的实际占用空间计算配额,否则按原始大小计算
+var physicalQuota bool
+
+// 设置是否压缩存储可压缩的文件
+func UseCompression(enabled bool) {
+	compression = enabled
+}
+
+// 设置配额按实际占用空间还是原始大小计算
+func UsePhysicalQuota(enabled bool) {
+	physicalQuota = enabled
+}
+
+// 编码器与解码器的EncodeAll/DecodeAll可以并发使用
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(2*blobChunkSize))
+)
+
+// 已经压缩过的文件类型,再次压缩收益很小
+var compressedExts = map[string]bool{
+	"jpg": true, "jpeg": true, "png": true, "gif": true, "webp": true, "heic": true,
+	"mp3": true, "mp4": true, "mkv": true, "avi": true, "mov": true, "flac": true, "aac": true,
+	"zip": true, "gz": true, "tgz": true, "bz2": true, "xz": true, "7z": true, "rar": true, "zst": true,
+	"docx": true, "xlsx": true, "pptx": true, "pdf": true, "apk": true, "jar": true,
+}
+
+// 判断文件是否值得压缩:跳过已压缩的类型和小文件,并试压缩开头的一块
+func compressible(f *File, data []byte) bool {
+	if !compression || len(data) < 512 || compressedExts[f.GetExt()] {
+		return false
+	}
+	sample := data
+	if len(sample) > blobChunkSize {
+		sample = sample[:blobChunkSize]
+	}
+	return len(zstdEncoder.EncodeAll(sample, nil)) < len(sample)*9/10
+}
+
+// 压缩一块数据,压缩后没有变小时原样保存
+func compressChunk(chunk []byte) []byte {
+	out := zstdEncoder.EncodeAll(chunk, []byte{chunkZstd})
+	if len(out) >= len(chunk)+1 {
+		return append([]byte{chunkStored}, chunk...)
+	}
+	return out
+}
+
+func decompressChunk(chunk []byte) ([]byte, error) {
+	if len(chunk) == 0 {
+		return nil, errBlobFormat
+	}
+	switch chunk[0] {
+	case chunkStored:
+		return chunk[1:], nil
+	case chunkZstd:
+		return zstdDecoder.DecodeAll(chunk[1:], nil)
+	}
+	return nil, errors.New("unknown chunk encoding")
+}
@@ -0,0 +1,58 @@
+package file
+
+import "errors"
+
+// 错误类型,调用者通过errors.Is判断,HTTP层据此返回状态码与错误码
+var (
+	// 参数不合法
+	ErrInvalid = errors.New("invalid argument")
+	// 文件不存在
+	ErrFileNotExist = errors.New("file not exist")
+	// 目标文件已存在
+	ErrFileExisted = errors.New("file existed")
+	// 用户无权对文件执行该操作
+	ErrForbidden = errors.New("permission denied")
+	// 用户剩余空间不足
+	ErrNoSpace = errors.New("no enough space")
+	// 上传的内容超过大小上限
+	ErrTooLarge = errors.New("content too large")
+	// 客户端提供的校验和与内容不一致
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// 文件已加密但未配置主密钥
+	ErrNoMasterKey = errors.New("master key not configured")
+	// 磁盘上的文件内容已损坏,如文件头、块长度表不合法或解密认证失败
+	ErrCorrupt = errors.New("stored content corrupted")
+)
+
+// 常见的具体错误
+var (
+	ErrNotUploader     = newError(ErrForbidden, "user is not uploader")
+	ErrNotTarget       = newError(ErrForbidden, "user is not target")
+	ErrNotOwner        = newError(ErrForbidden, "user doesn't own this file")
+	ErrInvalidArchive  = newError(ErrInvalid, "invalid archive format")
+	ErrInvalidLimit    = newError(ErrInvalid, "invalid limit")
+	ErrNothingToExport = newError(ErrInvalid, "no file to download")
+)
+
+// 带类型的错误,Error返回具体的错误信息,errors.Is可以判断其类型
+type Error struct {
+	kind error
+	msg  string
+}
+
+func newError(kind error, msg string) *Error {
+	return &Error{kind: kind, msg: msg}
+}
+
+// 参数不合法的错误
+func invalid(msg string) *Error {
+	return newError(ErrInvalid, msg)
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
@@ -0,0 +1,239 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 解压上传的限制,防止压缩炸弹
+const (
+	// 最多解压的文件数
+	maxArchiveEntries = 10000
+	// 解压后总大小与压缩包大小的最大比例
+	maxCompressionRatio = 200
+	// 解压后总大小上限,上传的压缩包也不能超过该大小
+	MaxExtractSize = 8 << 30
+	// 解压后文件名的最大长度
+	maxEntryName = 200
+)
+
+// 压缩包格式
+const (
+	ArchiveTar = "tar"
+)
+
+// 单个条目的解压结果
+type ExtractResult struct {
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// 根据文件头判断压缩包格式
+func detectArchive(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return ArchiveZip
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return ArchiveTarGz
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return ArchiveTar
+	}
+	return ""
+}
+
+// 检查并规范化用户目录下的文件名,拒绝绝对路径与路径穿越
+func CleanName(name string) (string, error) {
+	if strings.ContainsAny(name, "\\\x00") {
+		return "", invalid("invalid character in name")
+	}
+	if strings.HasPrefix(name, "/") || len(name) >= 2 && name[1] == ':' {
+		return "", invalid("absolute path not allowed")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", invalid("path traversal not allowed")
+		}
+	}
+	clean := path.Clean(name)
+	if clean == "." || len(clean) == 0 {
+		return "", invalid("empty name")
+	}
+	if len(clean) > maxEntryName {
+		return "", invalid("name too long")
+	}
+	return clean, nil
+}
+
+// 依次访问压缩包中的条目,regular为false表示目录、链接等非普通文件
+func walkArchive(src io.ReaderAt, size int64, format string, fn func(name string, regular bool, r io.Reader) error) error {
+	count := 0
+	next := func() error {
+		if count++; count > maxArchiveEntries {
+			return invalid("too many entries in archive")
+		}
+		return nil
+	}
+
+	switch format {
+	case ArchiveZip:
+		zr, err := zip.NewReader(src, size)
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			if err = next(); err != nil {
+				return err
+			}
+			if !zf.Mode().IsRegular() {
+				if err = fn(zf.Name, false, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			r, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(zf.Name, true, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case ArchiveTar, ArchiveTarGz:
+		var r io.Reader = io.NewSectionReader(src, 0, size)
+		if format == ArchiveTarGz {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = next(); err != nil {
+				return err
+			}
+			regular := hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA
+			if err = fn(hdr.Name, regular, tr); err != nil {
+				return err
+			}
+		}
+	}
+	return ErrInvalidArchive
+}
+
+// 解压上传的压缩包,每个普通文件保存为单独的文件记录
+//
+// 先完整扫描一遍检查文件名与解压后的总大小,总大小超过space或疑似压缩炸弹时不解压任何文件;
+// src为大小为size的压缩包内容;exists用于判断目标路径是否已存在,在保存每个文件前调用
+func extractArchive(userId, prefix string, src io.ReaderAt, size int64, format string, space int64, exists func(string) bool, db *gorm.DB) ([]*File, []ExtractResult, error) {
+	if len(format) == 0 {
+		head := make([]byte, 263)
+		n, _ := src.ReadAt(head, 0)
+		format = detectArchive(head[:n])
+	}
+	if len(prefix) > 0 {
+		var err error
+		if prefix, err = CleanName(prefix); err != nil {
+			return nil, nil, invalid("invalid prefix: " + err.Error())
+		}
+	}
+
+	limit := int64(MaxExtractSize)
+	if ratio := size * maxCompressionRatio; ratio < limit {
+		limit = ratio
+	}
+
+	// 第一遍:只计算每个文件解压后的大小,不信任压缩包中声明的大小
+	var total int64
+	sizes := make([]int64, 0)
+	err := walkArchive(src, size, format, func(name string, regular bool, r io.Reader) error {
+		if !regular {
+			return nil
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(r, limit-total+1))
+		if err != nil {
+			return err
+		}
+		if total += n; total > limit {
+			return invalid("archive expands too much")
+		}
+		sizes = append(sizes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if total > space {
+		return nil, nil, ErrNoSpace
+	}
+
+	// 第二遍:逐个流式保存文件,大小使用第一遍的结果,文件内容不读到内存中
+	files := make([]*File, 0)
+	results := make([]ExtractResult, 0)
+	seen := make(map[string]bool)
+	index := 0
+	err = walkArchive(src, size, format, func(name string, regular bool, r io.Reader) error {
+		res := ExtractResult{Name: name, Status: "fail"}
+		defer func() {
+			results = append(results, res)
+		}()
+		if !regular {
+			res.Status, res.Reason = "skipped", "not a regular file"
+			return nil
+		}
+		if index >= len(sizes) {
+			return invalid("archive changed while extracting")
+		}
+		res.Size = sizes[index]
+		index++
+		if strings.HasPrefix(name, "__MACOSX/") {
+			res.Status, res.Reason = "skipped", "metadata file"
+			return nil
+		}
+		clean, err := CleanName(name)
+		if err != nil {
+			res.Reason = err.Error()
+			return nil
+		}
+		if len(prefix) > 0 {
+			clean = prefix + "/" + clean
+		}
+		res.Path = userId + "/" + clean
+		if exists(res.Path) || seen[res.Path] {
+			res.Reason = "file existed"
+			return nil
+		}
+
+		f, err := saveFile(userId, clean, r, res.Size, db)
+		if err != nil {
+			res.Reason = err.Error()
+			return nil
+		}
+		seen[res.Path] = true
+		files = append(files, f)
+		res.Status = "success"
+		return nil
+	})
+	return files, results, err
+}
@@ -0,0 +1,211 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	data []byte
+	dir  bool
+}
+
+func buildZip(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		name := e.name
+		if e.dir {
+			name += "/"
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		if e.dir {
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(e.data)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestCleanName(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{"a.txt", "a.txt"},
+		{"dir/./b.txt", "dir/b.txt"},
+		{"dir//b.txt", "dir/b.txt"},
+		{"../evil.txt", ""},
+		{"dir/../../evil.txt", ""},
+		{"dir/..", ""},
+		{"/etc/passwd", ""},
+		{"C:/windows/evil.txt", ""},
+		{`dir\evil.txt`, ""},
+		{"nul\x00.txt", ""},
+		{".", ""},
+		{"", ""},
+		{strings.Repeat("a", maxEntryName+1), ""},
+	}
+	for _, c := range cases {
+		got, err := CleanName(c.name)
+		if len(c.want) == 0 {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("CleanName(%q) = %q, %v, want ErrInvalid", c.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("CleanName(%q) = %q, %v, want %q", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	hello := []byte("hello world")
+	cases := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		format  string
+		space   int64
+		exists  string
+		// 每个条目的结果,为空时表示整个压缩包被拒绝
+		status []string
+		err    error
+	}{
+		{"zip", func(t *testing.T) []byte {
+			return buildZip(t, testEntry{name: "docs", dir: true}, testEntry{name: "docs/a.txt", data: hello},
+				testEntry{name: "b.txt", data: nil})
+		}, "", 1 << 20, "", []string{"skipped", "success", "success"}, nil},
+		{"tar.gz", func(t *testing.T) []byte {
+			return buildTarGz(t, testEntry{name: "a.txt", data: hello}, testEntry{name: "sub", dir: true})
+		}, "", 1 << 20, "", []string{"success", "skipped"}, nil},
+		{"path traversal", func(t *testing.T) []byte {
+			return buildTarGz(t, testEntry{name: "../evil.txt", data: hello}, testEntry{name: "/abs.txt", data: hello},
+				testEntry{name: "ok/../../evil.txt", data: hello}, testEntry{name: "ok.txt", data: hello})
+		}, ArchiveTarGz, 1 << 20, "", []string{"fail", "fail", "fail", "success"}, nil},
+		{"existing and duplicate", func(t *testing.T) []byte {
+			return buildZip(t, testEntry{name: "a.txt", data: hello}, testEntry{name: "b.txt", data: hello},
+				testEntry{name: "b.txt", data: hello})
+		}, ArchiveZip, 1 << 20, "alice/a.txt", []string{"fail", "success", "fail"}, nil},
+		{"metadata", func(t *testing.T) []byte {
+			return buildZip(t, testEntry{name: "__MACOSX/._a.txt", data: hello})
+		}, "", 1 << 20, "", []string{"skipped"}, nil},
+		{"not enough space", func(t *testing.T) []byte {
+			return buildZip(t, testEntry{name: "a.txt", data: hello}, testEntry{name: "b.txt", data: hello})
+		}, "", int64(len(hello)), "", nil, ErrNoSpace},
+		{"too many entries", func(t *testing.T) []byte {
+			entries := make([]testEntry, maxArchiveEntries+1)
+			for i := range entries {
+				entries[i] = testEntry{name: "d", dir: true}
+			}
+			return buildZip(t, entries...)
+		}, "", 1 << 20, "", nil, ErrInvalid},
+		// 压缩比超过maxCompressionRatio的压缩炸弹
+		{"zip bomb", func(t *testing.T) []byte {
+			return buildZip(t, testEntry{name: "zeros", data: make([]byte, 32<<20)})
+		}, "", 1 << 40, "", nil, ErrInvalid},
+		{"tar bomb", func(t *testing.T) []byte {
+			return buildTarGz(t, testEntry{name: "a", data: make([]byte, 16<<20)}, testEntry{name: "b", data: make([]byte, 16<<20)})
+		}, "", 1 << 40, "", nil, ErrInvalid},
+		{"unknown format", func(t *testing.T) []byte {
+			return []byte("not an archive")
+		}, "", 1 << 20, "", nil, ErrInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			useTempDir(t)
+			db := dryRunDB(t)
+			data := c.archive(t)
+			exists := func(path string) bool { return path == c.exists }
+			files, results, err := extractArchive("alice", "", bytes.NewReader(data), int64(len(data)), c.format, c.space, exists, db)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+				// 拒绝的压缩包不保存任何文件
+				if len(files) > 0 {
+					t.Fatalf("saved %v files", len(files))
+				}
+				if _, err = os.Stat(StorageRoot); !os.IsNotExist(err) {
+					t.Fatalf("storage written: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(c.status) {
+				t.Fatalf("results = %+v", results)
+			}
+			saved := 0
+			for i, r := range results {
+				if r.Status != c.status[i] {
+					t.Errorf("entry %v: status = %v (%v), want %v", r.Name, r.Status, r.Reason, c.status[i])
+				}
+				if r.Status == "success" {
+					saved++
+				}
+			}
+			if len(files) != saved {
+				t.Fatalf("files = %v, want %v", len(files), saved)
+			}
+			for _, f := range files {
+				if !strings.HasPrefix(f.StoragePath(), StorageRoot+"/alice/") {
+					t.Fatalf("stored outside user directory: %v", f.StoragePath())
+				}
+				got := readContent(t, f)
+				if int64(len(got)) != f.Consume {
+					t.Fatalf("%v: size = %v, want %v", f.Path, len(got), f.Consume)
+				}
+			}
+			if _, err = os.Stat("evil.txt"); !os.IsNotExist(err) {
+				t.Fatal("entry escaped the storage directory")
+			}
+		})
+	}
+}
+
+func TestExtractArchivePrefix(t *testing.T) {
+	useTempDir(t)
+	db := dryRunDB(t)
+	data := buildZip(t, testEntry{name: "a.txt", data: []byte("a")})
+	none := func(string) bool { return false }
+	files, _, err := extractArchive("alice", "in/box", bytes.NewReader(data), int64(len(data)), "", 1<<20, none, db)
+	if err != nil || len(files) != 1 || files[0].Path != "alice/in/box/a.txt" {
+		t.Fatalf("files = %v, err = %v", files, err)
+	}
+	if _, _, err = extractArchive("alice", "../x", bytes.NewReader(data), int64(len(data)), "", 1<<20, none, db); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("err = %v, want ErrInvalid", err)
+	}
+}
@@ -1,17 +1,62 @@
 package file
 
 import (
+	"path"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// 文件存储的根目录
+const StorageRoot = "./storage"
+
 type File struct {
 	gorm.Model
-	Path     string `gorm:"column:file_path"`
-	Uploader string `gorm:"column:file_uploader"`
+	Path     string `gorm:"column:file_path;size:255;index"`
+	Name     string `gorm:"column:file_name;size:255;index"`
+	Uploader string `gorm:"column:file_uploader;size:64;index"`
 	Target   string `gorm:"column:share_target"`
-	Consume  int64  `gorm:"column:file_consume"`
+	Tags     string `gorm:"column:tags"`
+	Consume  int64  `gorm:"column:file_consume;index"`
+	// 文件内容的十六进制校验和
+	SHA256 string `gorm:"column:sha256;size:64;index"`
+	MD5    string `gorm:"column:md5;size:32"`
+	// 后台校验发现内容与校验和不一致
+	Corrupt   bool       `gorm:"column:corrupt"`
+	CheckedAt *time.Time `gorm:"column:checked_at"`
+	// 存储格式,以及加密存储时包装数据密钥的主密钥id与包装后的数据密钥
+	Format     string `gorm:"column:storage_format;size:16"`
+	KeyID      string `gorm:"column:key_id;size:32;index"`
+	WrappedKey string `gorm:"column:wrapped_key;size:128"`
+	// 压缩、加密后在磁盘上的实际占用空间
+	Stored int64 `gorm:"column:stored_size"`
+	// 上传时根据内容判断的MIME类型、小写扩展名与类别
+	Mime     string `gorm:"column:mime_type;size:127"`
+	Ext      string `gorm:"column:file_ext;size:32;index"`
+	Category string `gorm:"column:file_category;size:16;index"`
+}
+
+// 迁移文件表结构,补充索引并回填旧数据的文件名与类型
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&File{}, &Activity{}); err != nil {
+		return err
+	}
+	if !db.Migrator().HasIndex(&File{}, "idx_files_created_at") {
+		if err := db.Exec("CREATE INDEX idx_files_created_at ON files (created_at)").Error; err != nil {
+			return err
+		}
+	}
+	err := db.Model(&File{}).Where("file_name = ?", "").
+		Update("file_name", gorm.Expr("SUBSTRING(file_path, CHAR_LENGTH(file_uploader) + 2)")).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&File{}).Where("stored_size = 0").Update("stored_size", gorm.Expr("file_consume")).Error
+	if err != nil {
+		return err
+	}
+	return backfillTypes(db)
 }
 
 func (f *File) GetPath() string {
@@ -23,6 +68,16 @@ func (f *File) SetPath(path string) bool {
 	return true
 }
 
+// 获取文件名,即路径中上传者之后的部分
+func (f *File) GetName() string {
+	return strings.TrimPrefix(f.Path, f.Uploader+"/")
+}
+
+// 获取小写的文件扩展名,不含"."
+func (f *File) GetExt() string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(f.Path), "."))
+}
+
 func (f *File) GetUploader() string {
 	return f.Uploader
 }
@@ -32,6 +87,24 @@ func (f *File) SetUploader(user string) bool {
 	return true
 }
 
+// 文件在磁盘上的存储路径
+func (f *File) StoragePath() string {
+	return StorageRoot + "/" + f.Path
+}
+
+// 判断用户是否可以访问文件,即为上传者或分享目标
+func (f *File) Accessible(userId string) bool {
+	if userId == f.Uploader {
+		return true
+	}
+	for _, t := range f.GetTarget() {
+		if userId == t {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *File) GetTarget() []string {
 	if len(f.Target) == 0 {
 		return make([]string, 0)
@@ -41,10 +114,43 @@ func (f *File) GetTarget() []string {
 
 func (f *File) SetTarget(target string, db *gorm.DB) error {
 	f.Target = target
-	db.Model(f).Update("share_target", target)
-	return db.Error
+	return db.Model(f).Update("share_target", target).Error
+}
+
+func (f *File) GetTags() []string {
+	if len(f.Tags) == 0 {
+		return make([]string, 0)
+	}
+	return strings.Split(f.Tags, ",")
+}
+
+func (f *File) SetTags(tags string, db *gorm.DB) error {
+	f.Tags = tags
+	return db.Model(f).Update("tags", tags).Error
 }
 
 func (f *File) GetConsume() int64 {
 	return f.Consume
 }
+
+func (f *File) SetConsume(consume int64, db *gorm.DB) error {
+	f.Consume = consume
+	return db.Model(f).Update("file_consume", consume).Error
+}
+
+func (f *File) GetStored() int64 {
+	return f.Stored
+}
+
+func (f *File) SetStored(stored int64, db *gorm.DB) error {
+	f.Stored = stored
+	return db.Model(f).Update("stored_size", stored).Error
+}
+
+// 文件占用的配额,按配置使用原始大小或实际占用空间
+func (f *File) Charge() int64 {
+	if physicalQuota {
+		return f.Stored
+	}
+	return f.Consume
+}
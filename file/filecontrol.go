@@ -1,12 +1,15 @@
 package file
 
 import (
+	"context"
 	"net/http"
+	"tracing"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// 每个方法在请求的span下创建span,服务层与数据库操作的span是它的子span
 type FileController struct {
 	fileservice IFileService
 }
@@ -15,18 +18,104 @@ func (c *FileController) SetSrv(srv IFileService) {
 	c.fileservice = srv
 }
 
-func (c *FileController) UpdateTarget(f *File, target string, db *gorm.DB) error {
+func (c *FileController) UpdateTarget(f *File, target string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "FileController.UpdateTarget", tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
 	return c.fileservice.UpdateTarget(f, target, db)
 }
 
-func (c *FileController) UploadFile(userId, fileName string, req *http.Request, db *gorm.DB) (*File, error) {
+func (c *FileController) UploadFile(userId, fileName string, req *http.Request, db *gorm.DB) (res *File, err error) {
+	db, span := tracing.StartDB(db, "FileController.UploadFile", tracing.UserID(userId), tracing.Path(userId+"/"+fileName))
+	defer func() { tracing.End(span, err) }()
 	return c.fileservice.UploadFile(userId, fileName, req, db)
 }
 
-func (c *FileController) DownloadFile(f *File, userId string, ctx *gin.Context) error {
+func (c *FileController) UploadArchive(userId, prefix, format string, space int64, exists func(string) bool, req *http.Request, db *gorm.DB) (files []*File, results []ExtractResult, err error) {
+	db, span := tracing.StartDB(db, "FileController.UploadArchive", tracing.UserID(userId))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.UploadArchive(userId, prefix, format, space, exists, req, db)
+}
+
+func (c *FileController) DownloadFile(f *File, userId string, ctx *gin.Context) (err error) {
+	span, restore := tracing.StartGin(ctx, "FileController.DownloadFile", tracing.UserID(userId), tracing.Path(f.Path))
+	defer func() { restore(); tracing.End(span, err) }()
 	return c.fileservice.DownloadFile(f, userId, ctx)
 }
 
-func (c *FileController) DeleteFile(f *File, user_id string, db *gorm.DB) error {
+func (c *FileController) Thumbnail(f *File, userId, size string, ctx *gin.Context) (err error) {
+	span, restore := tracing.StartGin(ctx, "FileController.Thumbnail", tracing.UserID(userId), tracing.Path(f.Path))
+	defer func() { restore(); tracing.End(span, err) }()
+	return c.fileservice.Thumbnail(f, userId, size, ctx)
+}
+
+func (c *FileController) Preview(ctx context.Context, f *File, userId string, limit int) (res Preview, err error) {
+	ctx, span := tracing.Start(ctx, "FileController.Preview", tracing.UserID(userId), tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.Preview(ctx, f, userId, limit)
+}
+
+func (c *FileController) DeleteFile(f *File, user_id string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "FileController.DeleteFile", tracing.UserID(user_id), tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
 	return c.fileservice.DeleteFile(f, user_id, db)
 }
+
+func (c *FileController) StageDelete(f File, userId string, db *gorm.DB) (staged string, err error) {
+	db, span := tracing.StartDB(db, "FileController.StageDelete", tracing.UserID(userId), tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.StageDelete(f, userId, db)
+}
+
+func (c *FileController) MoveFile(f File, userId, dest string, db *gorm.DB) (res File, err error) {
+	db, span := tracing.StartDB(db, "FileController.MoveFile", tracing.UserID(userId), tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.MoveFile(f, userId, dest, db)
+}
+
+func (c *FileController) CopyFile(f File, userId, dest string, db *gorm.DB) (res *File, err error) {
+	db, span := tracing.StartDB(db, "FileController.CopyFile", tracing.UserID(userId), tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.CopyFile(f, userId, dest, db)
+}
+
+func (c *FileController) ListFiles(ctx context.Context, files []*File, userId string, opt ListOption) (res []*File, next string, err error) {
+	ctx, span := tracing.Start(ctx, "FileController.ListFiles", tracing.UserID(userId))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.ListFiles(ctx, files, userId, opt)
+}
+
+func (c *FileController) SearchFiles(userId string, q SearchQuery, db *gorm.DB) (res []File, err error) {
+	db, span := tracing.StartDB(db, "FileController.SearchFiles", tracing.UserID(userId))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.SearchFiles(userId, q, db)
+}
+
+func (c *FileController) UpdateTags(f *File, tags []string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "FileController.UpdateTags", tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.UpdateTags(f, tags, db)
+}
+
+func (c *FileController) Stats(days int, db *gorm.DB) (res FileStats, err error) {
+	db, span := tracing.StartDB(db, "FileController.Stats")
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.Stats(days, db)
+}
+
+func (c *FileController) Scrub(f File, db *gorm.DB) (res ScrubResult, err error) {
+	db, span := tracing.StartDB(db, "FileController.Scrub", tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.Scrub(f, db)
+}
+
+func (c *FileController) Rewrap(f File, db *gorm.DB) (from, to string, err error) {
+	db, span := tracing.StartDB(db, "FileController.Rewrap", tracing.Path(f.Path))
+	defer func() { tracing.End(span, err) }()
+	return c.fileservice.Rewrap(f, db)
+}
+
+func (c *FileController) DownloadArchive(files []File, userId, format string, skipped []string, ctx *gin.Context) (res ArchiveResult, err error) {
+	span, restore := tracing.StartGin(ctx, "FileController.DownloadArchive", tracing.UserID(userId))
+	defer func() { restore(); tracing.End(span, err) }()
+	return c.fileservice.DownloadArchive(files, userId, format, skipped, ctx)
+}
@@ -0,0 +1,178 @@
+package file
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// 文件列表的查询条件
+type ListOption struct {
+	// 范围:all/owned/shared
+	Scope string
+	// 上传者
+	Uploader string
+	// 扩展名,不含"."
+	Ext string
+	// 文件类别
+	Category string
+	// 文件大小范围,nil表示不限制
+	MinSize *int64
+	MaxSize *int64
+	// 排序字段:name/size/created/updated
+	Sort string
+	// 是否降序
+	Desc bool
+	// 上一页返回的游标
+	Cursor string
+	// 每页数量
+	Limit int
+}
+
+// 分页游标,记录上一页最后一个文件的排序键
+type listCursor struct {
+	Sort string `json:"o"`
+	Desc bool   `json:"d"`
+	Str  string `json:"s"`
+	Num  int64  `json:"n"`
+	ID   uint   `json:"i"`
+}
+
+func (c listCursor) compare(o listCursor) int {
+	if r := strings.Compare(c.Str, o.Str); r != 0 {
+		return r
+	}
+	if c.Num != o.Num {
+		if c.Num < o.Num {
+			return -1
+		}
+		return 1
+	}
+	if c.ID != o.ID {
+		if c.ID < o.ID {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, invalid("invalid cursor")
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return c, invalid("invalid cursor")
+	}
+	return c, nil
+}
+
+// 获取文件在指定排序字段下的排序键
+func sortKey(f *File, by string) listCursor {
+	key := listCursor{ID: f.ID}
+	switch by {
+	case "size":
+		key.Num = f.GetConsume()
+	case "created":
+		key.Num = f.CreatedAt.UnixNano()
+	case "updated":
+		key.Num = f.UpdatedAt.UnixNano()
+	default:
+		key.Str = f.GetName()
+	}
+	return key
+}
+
+// 判断文件是否满足过滤条件
+func (opt *ListOption) match(f *File, userId string) bool {
+	owned := f.GetUploader() == userId
+	if opt.Scope == "owned" && !owned || opt.Scope == "shared" && owned {
+		return false
+	}
+	if len(opt.Uploader) > 0 && f.GetUploader() != opt.Uploader {
+		return false
+	}
+	if len(opt.Ext) > 0 && f.GetExt() != strings.ToLower(strings.TrimPrefix(opt.Ext, ".")) {
+		return false
+	}
+	if len(opt.Category) > 0 && f.Category != opt.Category {
+		return false
+	}
+	if opt.MinSize != nil && f.GetConsume() < *opt.MinSize {
+		return false
+	}
+	if opt.MaxSize != nil && f.GetConsume() > *opt.MaxSize {
+		return false
+	}
+	return true
+}
+
+// 对文件列表过滤、排序并分页
+//
+// 返回当前页的文件与下一页的游标,没有下一页时游标为空
+func listFiles(files []*File, userId string, opt ListOption) ([]*File, string, error) {
+	switch opt.Scope {
+	case "", "all", "owned", "shared":
+	default:
+		return nil, "", invalid("invalid scope")
+	}
+	switch opt.Sort {
+	case "":
+		opt.Sort = "name"
+	case "name", "size", "created", "updated":
+	default:
+		return nil, "", invalid("invalid sort")
+	}
+	if opt.Limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	var after *listCursor
+	if len(opt.Cursor) > 0 {
+		c, err := decodeCursor(opt.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.Sort != opt.Sort || c.Desc != opt.Desc {
+			return nil, "", invalid("cursor does not match sort")
+		}
+		after = &c
+	}
+
+	res := make([]*File, 0)
+	for _, f := range files {
+		if !opt.match(f, userId) {
+			continue
+		}
+		if after != nil {
+			r := sortKey(f, opt.Sort).compare(*after)
+			if opt.Desc && r >= 0 || !opt.Desc && r <= 0 {
+				continue
+			}
+		}
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		r := sortKey(res[i], opt.Sort).compare(sortKey(res[j], opt.Sort))
+		if opt.Desc {
+			return r > 0
+		}
+		return r < 0
+	})
+
+	if len(res) <= opt.Limit {
+		return res, "", nil
+	}
+	res = res[:opt.Limit]
+	next := sortKey(res[len(res)-1], opt.Sort)
+	next.Sort, next.Desc = opt.Sort, opt.Desc
+	return res, next.encode(), nil
+}
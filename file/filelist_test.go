@@ -0,0 +1,120 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testFile(id uint, uploader, name string, size int64, created time.Time) *File {
+	f := &File{Path: uploader + "/" + name, Name: name, Uploader: uploader, Consume: size}
+	f.ID, f.CreatedAt, f.UpdatedAt = id, created, created
+	return f
+}
+
+func TestListFilesCursor(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		sort string
+		desc bool
+	}{
+		{"name", false},
+		{"name", true},
+		{"size", false},
+		{"size", true},
+		{"created", false},
+		{"updated", true},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%v desc=%v", c.sort, c.desc), func(t *testing.T) {
+			// 大小相同的文件按id区分,保证排序稳定
+			files := make([]*File, 0)
+			for i := 0; i < 7; i++ {
+				files = append(files, testFile(uint(i+1), "alice", fmt.Sprintf("f%d.txt", i*2), int64(i/2), base.Add(time.Duration(i)*time.Hour)))
+			}
+			want := make(map[string]bool)
+			for _, f := range files {
+				want[f.Path] = true
+			}
+
+			seen := make(map[string]bool)
+			cursor := ""
+			for page := 0; ; page++ {
+				res, next, err := listFiles(files, "alice", ListOption{Sort: c.sort, Desc: c.desc, Cursor: cursor, Limit: 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range res {
+					if seen[f.Path] {
+						t.Fatalf("page %d: %v listed twice", page, f.Path)
+					}
+					seen[f.Path] = true
+				}
+				if len(next) == 0 {
+					break
+				}
+				cursor = next
+				// 翻页之间插入新文件,已有的文件既不重复也不遗漏
+				id := uint(100 + page)
+				files = append(files, testFile(id, "alice", fmt.Sprintf("f%d.txt", 2*page+1), int64(page), base.Add(time.Duration(page)*time.Hour+time.Minute)))
+			}
+			for p := range want {
+				if !seen[p] {
+					t.Fatalf("%v skipped", p)
+				}
+			}
+		})
+	}
+}
+
+func TestListFilesOptions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	shared := testFile(3, "bob", "c.png", 30, base)
+	shared.Target = "alice"
+	files := []*File{
+		testFile(1, "alice", "a.txt", 10, base),
+		testFile(2, "alice", "b.png", 20, base),
+		shared,
+	}
+	minSize := int64(15)
+	cursor := listCursor{Sort: "size"}.encode()
+	cases := []struct {
+		name string
+		opt  ListOption
+		want []string
+		err  error
+	}{
+		{"all by name", ListOption{Limit: 10}, []string{"alice/a.txt", "alice/b.png", "bob/c.png"}, nil},
+		{"owned", ListOption{Scope: "owned", Limit: 10}, []string{"alice/a.txt", "alice/b.png"}, nil},
+		{"shared", ListOption{Scope: "shared", Limit: 10}, []string{"bob/c.png"}, nil},
+		{"ext", ListOption{Ext: ".PNG", Limit: 10}, []string{"alice/b.png", "bob/c.png"}, nil},
+		{"min size desc", ListOption{MinSize: &minSize, Sort: "size", Desc: true, Limit: 10}, []string{"bob/c.png", "alice/b.png"}, nil},
+		{"invalid scope", ListOption{Scope: "mine", Limit: 10}, nil, ErrInvalid},
+		{"invalid sort", ListOption{Sort: "owner", Limit: 10}, nil, ErrInvalid},
+		{"invalid limit", ListOption{}, nil, ErrInvalidLimit},
+		{"invalid cursor", ListOption{Cursor: "!", Limit: 10}, nil, ErrInvalid},
+		{"cursor for other sort", ListOption{Cursor: cursor, Limit: 10}, nil, ErrInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, _, err := listFiles(files, "alice", c.opt)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(res))
+			for i, f := range res {
+				got[i] = f.Path
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// 待删除文件内容的暂存目录,批量操作提交后才真正删除
+const PendingRoot = QuarantineRoot + "/.pending"
+
+// 移动或重命名文件,dest为移动后上传者目录下的文件名
+//
+// 只修改f的副本,返回移动后的文件;数据库更新失败时恢复磁盘上的文件
+func moveFile(f File, userId, dest string, db *gorm.DB) (File, error) {
+	if f.Uploader != userId {
+		return f, ErrNotUploader
+	}
+	name, err := CleanName(dest)
+	if err != nil {
+		return f, err
+	}
+	if f.Path == userId+"/"+name {
+		return f, invalid("source and destination are the same")
+	}
+	from := f.StoragePath()
+	f.Path, f.Name = userId+"/"+name, name
+	to := f.StoragePath()
+	if err = os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+		return f, err
+	}
+	if err = moveBlob(from, to); err != nil {
+		return f, err
+	}
+	// 扩展名可能改变,类别按新扩展名重新判断,MIME类型保留上传时的嗅探结果
+	t, ext, cat := guessType(name)
+	if len(f.Mime) > 0 {
+		cat = category(f.Mime, ext)
+	} else {
+		f.Mime = t
+	}
+	f.Ext, f.Category = ext, cat
+	err = db.Model(&File{}).Where("id = ?", f.ID).Updates(map[string]interface{}{
+		"file_path":     f.Path,
+		"file_name":     f.Name,
+		"mime_type":     f.Mime,
+		"file_ext":      f.Ext,
+		"file_category": f.Category,
+	}).Error
+	if err != nil {
+		if rerr := moveBlob(to, from); rerr != nil {
+			return f, fmt.Errorf("%w when updating file record, %v when restoring %v", err, rerr, from)
+		}
+		return f, fmt.Errorf("%w when updating file record", err)
+	}
+	return f, nil
+}
+
+// 复制文件为用户自己的新文件,用户需为上传者或分享目标
+//
+// 文件内容重新写入,使用新的数据密钥;上传者复制自己的文件时保留标签
+func copyFile(f File, userId, dest string, db *gorm.DB) (*File, error) {
+	if !f.Accessible(userId) {
+		return nil, ErrNotTarget
+	}
+	name, err := CleanName(dest)
+	if err != nil {
+		return nil, err
+	}
+	c, err := OpenContent(&f)
+	if err != nil {
+		return nil, err
+	}
+	res, err := saveFile(userId, name, c, c.Size(), db)
+	c.Close()
+	if err != nil {
+		return nil, err
+	}
+	if f.Uploader == userId && len(f.Tags) > 0 {
+		if err = res.SetTags(f.Tags, db); err != nil {
+			db.Delete(res)
+			os.Remove(res.StoragePath())
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// 删除文件记录,文件内容移入暂存目录
+//
+// 返回暂存路径,调用者确认后使用DropStaged删除,或使用RestoreBlob恢复
+func stageDelete(f File, userId string, db *gorm.DB) (string, error) {
+	if f.Uploader != userId {
+		return "", ErrNotUploader
+	}
+	staged := filepath.Join(PendingRoot, fmt.Sprint(f.ID))
+	if err := os.MkdirAll(PendingRoot, 0777); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.StoragePath(), staged); err != nil {
+		return "", err
+	}
+	if err := db.Where("id = ?", f.ID).Delete(&File{}).Error; err != nil {
+		moveBlob(staged, f.StoragePath())
+		return "", err
+	}
+	return staged, nil
+}
+
+// 将暂存或移动过的文件内容恢复到文件的存储路径,存储路径已被其他文件占用时返回ErrFileExisted
+func RestoreBlob(f *File, from string) error {
+	return moveBlob(from, f.StoragePath())
+}
+
+// 将文件内容移到新的路径,目标已存在时返回ErrFileExisted,不覆盖其他文件
+//
+// 先链接再删除原路径,链接在目标已存在时失败,避免检查与重命名之间的竞争
+func moveBlob(from, to string) error {
+	if err := os.Link(from, to); err != nil {
+		if os.IsExist(err) {
+			return newError(ErrFileExisted, "destination existed")
+		}
+		return err
+	}
+	if err := os.Remove(from); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return nil
+}
+
+// 删除暂存的文件内容
+func DropStaged(staged string) error {
+	return os.Remove(staged)
+}
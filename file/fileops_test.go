@@ -0,0 +1,77 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestMoveFile(t *testing.T) {
+	errDB := errors.New("db down")
+	cases := []struct {
+		name   string
+		dest   string
+		failDB bool
+		err    error
+	}{
+		{"rename", "b.txt", false, nil},
+		{"into directory", "docs/a.md", false, nil},
+		{"destination existed", "c.txt", false, ErrFileExisted},
+		{"same path", "a.txt", false, ErrInvalid},
+		{"db failure", "b.txt", true, errDB},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			useTempDir(t)
+			db := dryRunDB(t)
+			f, err := saveBytes("alice", "a.txt", []byte("moving"), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := saveBytes("alice", "c.txt", []byte("staying"), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.failDB {
+				db.Callback().Update().Before("gorm:update").Register("test:fail", func(tx *gorm.DB) {
+					tx.AddError(errDB)
+				})
+			}
+
+			moved, err := moveFile(*f, "alice", c.dest, db)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+				// 失败时原文件和目标位置的文件都保持不变
+				if !bytes.Equal(readContent(t, f), []byte("moving")) {
+					t.Fatal("source content lost")
+				}
+				if !bytes.Equal(readContent(t, other), []byte("staying")) {
+					t.Fatal("destination overwritten")
+				}
+				if c.failDB {
+					if _, err = os.Stat(moved.StoragePath()); !os.IsNotExist(err) {
+						t.Fatalf("moved content left at %v", moved.StoragePath())
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved.Path != "alice/"+c.dest {
+				t.Fatalf("path = %v", moved.Path)
+			}
+			if !bytes.Equal(readContent(t, &moved), []byte("moving")) {
+				t.Fatal("content differs")
+			}
+			if _, err = os.Stat(f.StoragePath()); !os.IsNotExist(err) {
+				t.Fatal("source content not removed")
+			}
+		})
+	}
+}
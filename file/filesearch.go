@@ -0,0 +1,105 @@
+package file
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 文件搜索条件,零值字段表示不限制
+type SearchQuery struct {
+	// 文件名匹配模式
+	Name string
+	// 匹配方式:substring/prefix/glob
+	Match string
+	// 上传者
+	Uploader string
+	// 文件类别
+	Category string
+	// 需要同时包含的标签
+	Tags []string
+	// 文件大小范围
+	MinSize *int64
+	MaxSize *int64
+	// 上传时间范围
+	After  *time.Time
+	Before *time.Time
+	// 分页
+	Offset int
+	Limit  int
+}
+
+// 转义LIKE中的特殊字符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// 将glob模式转换为LIKE模式,支持*和?
+func globToLike(s string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(s))
+}
+
+// 规范化标签列表:去除空白、转为小写并去重
+func NormalizeTags(raw []string) ([]string, error) {
+	tags := make([]string, 0, len(raw))
+	seen := make(map[string]bool, len(raw))
+	for _, t := range raw {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if len(t) == 0 || seen[t] {
+			continue
+		}
+		if len(t) > 32 {
+			return nil, invalid("tag too long")
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > 20 {
+		return nil, invalid("tag limit exceed")
+	}
+	return tags, nil
+}
+
+// 在用户可访问的文件(自己上传或分享给自己)中搜索
+func searchFiles(userId string, q SearchQuery, db *gorm.DB) ([]File, error) {
+	tx := db.Model(&File{}).Where("file_uploader = ? OR FIND_IN_SET(?, share_target) > 0", userId, userId)
+
+	if len(q.Name) > 0 {
+		switch q.Match {
+		case "", "substring":
+			tx = tx.Where("file_name LIKE ?", "%"+escapeLike(q.Name)+"%")
+		case "prefix":
+			tx = tx.Where("file_name LIKE ?", escapeLike(q.Name)+"%")
+		case "glob":
+			tx = tx.Where("file_name LIKE ?", globToLike(q.Name))
+		default:
+			return nil, invalid("invalid match")
+		}
+	}
+	if len(q.Uploader) > 0 {
+		tx = tx.Where("file_uploader = ?", q.Uploader)
+	}
+	if len(q.Category) > 0 {
+		tx = tx.Where("file_category = ?", q.Category)
+	}
+	for _, t := range q.Tags {
+		tx = tx.Where("FIND_IN_SET(?, tags) > 0", t)
+	}
+	if q.MinSize != nil {
+		tx = tx.Where("file_consume >= ?", *q.MinSize)
+	}
+	if q.MaxSize != nil {
+		tx = tx.Where("file_consume <= ?", *q.MaxSize)
+	}
+	if q.After != nil {
+		tx = tx.Where("created_at >= ?", *q.After)
+	}
+	if q.Before != nil {
+		tx = tx.Where("created_at < ?", *q.Before)
+	}
+
+	var files []File
+	err := tx.Order("file_name").Order("id").Offset(q.Offset).Limit(q.Limit).Find(&files).Error
+	return files, err
+}
@@ -1,11 +1,19 @@
 package file
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"logger"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"strings"
+	"tracing"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -16,82 +24,291 @@ type IFileService interface {
 	UpdateTarget(*File, string, *gorm.DB) error
 	// 上传文件
 	UploadFile(string, string, *http.Request, *gorm.DB) (*File, error)
+	// 上传压缩包并解压为多个文件
+	UploadArchive(string, string, string, int64, func(string) bool, *http.Request, *gorm.DB) ([]*File, []ExtractResult, error)
 	// 下载文件
 	DownloadFile(*File, string, *gin.Context) error
+	// 获取图片文件的缩略图
+	Thumbnail(*File, string, string, *gin.Context) error
+	// 预览文本文件的开头部分
+	Preview(context.Context, *File, string, int) (Preview, error)
 	// 删除文件
 	DeleteFile(*File, string, *gorm.DB) error
+	// 删除文件记录并暂存文件内容
+	StageDelete(File, string, *gorm.DB) (string, error)
+	// 移动或重命名文件
+	MoveFile(File, string, string, *gorm.DB) (File, error)
+	// 复制文件为用户自己的文件
+	CopyFile(File, string, string, *gorm.DB) (*File, error)
+	// 过滤、排序并分页文件列表
+	ListFiles(context.Context, []*File, string, ListOption) ([]*File, string, error)
+	// 搜索用户可访问的文件
+	SearchFiles(string, SearchQuery, *gorm.DB) ([]File, error)
+	// 更新文件标签
+	UpdateTags(*File, []string, *gorm.DB) error
+	// 文件存储与活动统计
+	Stats(int, *gorm.DB) (FileStats, error)
+	// 重新校验文件内容
+	Scrub(File, *gorm.DB) (ScrubResult, error)
+	// 使用当前主密钥重新包装数据密钥
+	Rewrap(File, *gorm.DB) (string, string, error)
+	// 打包下载多个文件
+	DownloadArchive([]File, string, string, []string, *gin.Context) (ArchiveResult, error)
 }
 
 type FileServiceImpl struct{}
 
 // 更新文件分享目标
-func (fi FileServiceImpl) UpdateTarget(f *File, target string, db *gorm.DB) error {
+func (fi FileServiceImpl) UpdateTarget(f *File, target string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "FileService.UpdateTarget")
+	defer func() { tracing.End(span, err) }()
 	return f.SetTarget(target, db)
 }
 
 // 上传文件
 //
-// 创建文件夹、读文件数据到内存、创建文件、写文件、更新文件数据库
-func (fi FileServiceImpl) UploadFile(userId, fileName string, req *http.Request, db *gorm.DB) (*File, error) {
-	// 创建对应用户的文件夹
-	err := os.Mkdir("./storage/"+userId, 0777)
-	if err != nil && !strings.Contains(err.Error(), "file exists") {
-		return nil, err
+// 边读取边写入临时文件并计算校验和,与客户端提供的校验和一致时再保存文件、更新文件数据库
+func (fi FileServiceImpl) UploadFile(userId, fileName string, req *http.Request, db *gorm.DB) (res *File, err error) {
+	db, span := tracing.StartDB(db, "FileService.UploadFile")
+	defer func() { tracing.End(span, err) }()
+	verify := func(sum Checksum) error {
+		return VerifyChecksum(req.Header, sum)
 	}
+	return storeFile(userId, fileName, io.LimitReader(req.Body, req.ContentLength), req.ContentLength, verify, db)
+}
 
-	// 读取上传的文件数据到内存
-	data := make([]byte, req.ContentLength)
-	_, err = io.ReadFull(req.Body, data)
-	if err != nil {
-		return nil, fmt.Errorf("%v when reading file data", err)
+// 保存r中size字节的文件内容并创建文件记录
+func saveFile(userId, fileName string, r io.Reader, size int64, db *gorm.DB) (*File, error) {
+	return storeFile(userId, fileName, r, size, nil, db)
+}
+
+// 保存文件内容并创建文件记录,verify不为nil时在保存前检查内容的校验和
+//
+// 内容按块流式写入,r在size字节后必须结束,压缩包条目的CRC等校验在读到结尾时进行
+func storeFile(userId, fileName string, r io.Reader, size int64, verify func(Checksum) error, db *gorm.DB) (*File, error) {
+	// 开头的一块用于判断类型与是否值得压缩
+	head := make([]byte, min(size, blobChunkSize))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("%w when reading file data", err)
+	}
+	res := &File{
+		Path:     userId + "/" + fileName,
+		Name:     fileName,
+		Uploader: userId,
+		Target:   "",
+		Consume:  size,
 	}
+	res.setType(DetectType(fileName, head))
 
-	// 创建对应文件
-	path := strings.Join([]string{"./storage", userId, fileName}, "/")
-	f, err := os.Create(path)
-	if err != nil {
-		return nil, fmt.Errorf("%v when creating uploaded file", err)
+	cw := newChecksumWriter()
+	src := io.TeeReader(io.MultiReader(bytes.NewReader(head), r), cw)
+	check := func() error {
+		if _, err := io.ReadFull(r, make([]byte, 1)); err != io.EOF {
+			if err == nil {
+				err = invalid("content longer than declared size")
+			}
+			return err
+		}
+		sum := cw.Sum()
+		res.SHA256, res.MD5 = hex.EncodeToString(sum.SHA256), hex.EncodeToString(sum.MD5)
+		if verify != nil {
+			return verify(sum)
+		}
+		return nil
 	}
-	defer f.Close()
 
-	// 写入数据
-	_, err = f.Write(data)
+	// 写入文件,配置了主密钥时加密存储
+	_, span := tracing.StartDB(db, "storage.write", tracing.Path(res.Path))
+	err := writeContent(res, src, size, compressible(res, head), check)
+	tracing.End(span, err)
+	if errors.Is(err, ErrFileExisted) {
+		return nil, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("%v when writing data", err)
+		return nil, fmt.Errorf("%w when writing data", err)
 	}
-
-	res := &File{Path: userId + "/" + fileName, Uploader: userId, Target: "", Consume: req.ContentLength}
-	db.Create(res)
+	if err := db.Create(res).Error; err != nil {
+		os.Remove(res.StoragePath())
+		return nil, fmt.Errorf("%v when creating file record", err)
+	}
+	logger.FromDB(db).Debug("file stored", "path", res.Path, "size", res.Consume, "stored", res.Stored, "format", res.Format)
 	return res, nil
 }
 
-func (fi FileServiceImpl) DownloadFile(f *File, userId string, ctx *gin.Context) error {
+// 上传压缩包
+//
+// 压缩包写入临时文件、校验、检查解压后大小、逐个解压并创建文件记录;
+// 压缩包超过剩余空间或MaxExtractSize时不读取请求内容
+func (fi FileServiceImpl) UploadArchive(userId, prefix, format string, space int64, exists func(string) bool, req *http.Request, db *gorm.DB) (files []*File, results []ExtractResult, err error) {
+	db, span := tracing.StartDB(db, "FileService.UploadArchive")
+	defer func() { tracing.End(span, err) }()
+	if req.ContentLength > space {
+		return nil, nil, ErrNoSpace
+	}
+	if req.ContentLength > MaxExtractSize {
+		return nil, nil, ErrTooLarge
+	}
+
+	// 压缩包可能很大,不读到内存中
+	tmp, err := os.CreateTemp("", "archive-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sum, err := ReadChecksum(io.TeeReader(io.LimitReader(req.Body, req.ContentLength), tmp))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w when reading archive data", err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size < req.ContentLength {
+		return nil, nil, fmt.Errorf("%w when reading archive data", io.ErrUnexpectedEOF)
+	}
+	if err = VerifyChecksum(req.Header, sum); err != nil {
+		return nil, nil, err
+	}
+	return extractArchive(userId, prefix, tmp, size, format, space, exists, db)
+}
+
+func (fi FileServiceImpl) DownloadFile(f *File, userId string, ctx *gin.Context) (err error) {
+	span, restore := tracing.StartGin(ctx, "FileService.DownloadFile")
+	defer func() { restore(); tracing.End(span, err) }()
 	// 用户不是上传者且不是该文件分享的目标
-	if userId != f.GetUploader() {
-		find := false
-		target := f.GetTarget()
-		for _, t := range target {
-			if userId == t {
-				find = true
-				break
-			}
-		}
-		if !find {
-			return fmt.Errorf("user is not target")
-		}
+	if !f.Accessible(userId) {
+		return ErrNotTarget
+	}
+	c, err := OpenContent(f)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// 范围请求返回206时内容只是文件的一部分
+	setChecksumHeaders(ctx.Writer.Header(), f, len(ctx.GetHeader("Range")) > 0)
+	// 不允许浏览器根据内容猜测类型,不能直接打开的类型作为附件下载
+	t, inline := ServeType(f)
+	ctx.Header("Content-Type", t)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	if !inline {
+		ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(f.GetName())}))
 	}
-	ctx.File("./storage/" + f.Path)
+	http.ServeContent(ctx.Writer, ctx.Request, f.GetName(), f.UpdatedAt, c)
 	return nil
 }
 
-func (fi FileServiceImpl) DeleteFile(f *File, user_id string, db *gorm.DB) error {
+func (fi FileServiceImpl) Thumbnail(f *File, userId, size string, ctx *gin.Context) (err error) {
+	span, restore := tracing.StartGin(ctx, "FileService.Thumbnail")
+	defer func() { restore(); tracing.End(span, err) }()
+	return serveThumbnail(f, userId, size, ctx)
+}
+
+func (fi FileServiceImpl) Preview(ctx context.Context, f *File, userId string, limit int) (res Preview, err error) {
+	_, span := tracing.Start(ctx, "FileService.Preview")
+	defer func() { tracing.End(span, err) }()
+	return previewFile(f, userId, limit)
+}
+
+func (fi FileServiceImpl) DeleteFile(f *File, user_id string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "FileService.DeleteFile")
+	defer func() { tracing.End(span, err) }()
 	if f.GetUploader() != user_id {
-		return fmt.Errorf("user is not uploader")
+		return ErrNotUploader
+	}
+	if err := db.Where("file_path", f.GetPath()).Delete(&File{}).Error; err != nil {
+		return err
 	}
-	db.Where("file_path", f.GetPath()).Delete(&File{})
-	if db.Error != nil {
-		return db.Error
+	if err := os.Remove(f.StoragePath()); err != nil {
+		return err
 	}
-	err := os.Remove("./storage/" + f.GetPath())
-	return err
+	logger.FromDB(db).Debug("file deleted", "path", f.GetPath())
+	return nil
+}
+
+func (fi FileServiceImpl) StageDelete(f File, userId string, db *gorm.DB) (staged string, err error) {
+	db, span := tracing.StartDB(db, "FileService.StageDelete")
+	defer func() { tracing.End(span, err) }()
+	return stageDelete(f, userId, db)
+}
+
+func (fi FileServiceImpl) MoveFile(f File, userId, dest string, db *gorm.DB) (res File, err error) {
+	db, span := tracing.StartDB(db, "FileService.MoveFile")
+	defer func() { tracing.End(span, err) }()
+	return moveFile(f, userId, dest, db)
+}
+
+func (fi FileServiceImpl) CopyFile(f File, userId, dest string, db *gorm.DB) (res *File, err error) {
+	db, span := tracing.StartDB(db, "FileService.CopyFile")
+	defer func() { tracing.End(span, err) }()
+	return copyFile(f, userId, dest, db)
+}
+
+// 过滤、排序并分页用户可下载的文件列表
+func (fi FileServiceImpl) ListFiles(ctx context.Context, files []*File, userId string, opt ListOption) (res []*File, next string, err error) {
+	_, span := tracing.Start(ctx, "FileService.ListFiles")
+	defer func() { tracing.End(span, err) }()
+	return listFiles(files, userId, opt)
+}
+
+// 在用户自己上传和分享给用户的文件中搜索
+func (fi FileServiceImpl) SearchFiles(userId string, q SearchQuery, db *gorm.DB) (res []File, err error) {
+	db, span := tracing.StartDB(db, "FileService.SearchFiles")
+	defer func() { tracing.End(span, err) }()
+	return searchFiles(userId, q, db)
+}
+
+// 更新文件标签
+func (fi FileServiceImpl) UpdateTags(f *File, raw []string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "FileService.UpdateTags")
+	defer func() { tracing.End(span, err) }()
+	tags, err := NormalizeTags(raw)
+	if err != nil {
+		return err
+	}
+	return f.SetTags(strings.Join(tags, ","), db)
+}
+
+// 统计文件存储情况与最近的上传下载活动
+func (fi FileServiceImpl) Stats(days int, db *gorm.DB) (res FileStats, err error) {
+	db, span := tracing.StartDB(db, "FileService.Stats")
+	defer func() { tracing.End(span, err) }()
+	return collectStats(days, db)
+}
+
+// 重新计算文件校验和,检查存储的内容是否损坏
+func (fi FileServiceImpl) Scrub(f File, db *gorm.DB) (res ScrubResult, err error) {
+	db, span := tracing.StartDB(db, "FileService.Scrub")
+	defer func() { tracing.End(span, err) }()
+	return scrub(f, db)
+}
+
+// 使用当前主密钥重新包装文件的数据密钥
+func (fi FileServiceImpl) Rewrap(f File, db *gorm.DB) (from, to string, err error) {
+	db, span := tracing.StartDB(db, "FileService.Rewrap")
+	defer func() { tracing.End(span, err) }()
+	return rewrapKey(f, db)
+}
+
+// 打包下载多个文件,以zip或tar.gz格式流式返回
+//
+// skipped为调用者已经判定需要跳过的文件,会与打包时跳过的文件一起列在压缩包中
+func (fi FileServiceImpl) DownloadArchive(files []File, userId, format string, skipped []string, ctx *gin.Context) (res ArchiveResult, err error) {
+	span, restore := tracing.StartGin(ctx, "FileService.DownloadArchive")
+	defer func() { restore(); tracing.End(span, err) }()
+	name := "archive.zip"
+	contentType := "application/zip"
+	if format == ArchiveTarGz {
+		name, contentType = "archive.tar.gz", "application/gzip"
+	} else if format != ArchiveZip {
+		return ArchiveResult{}, ErrInvalidArchive
+	}
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	ctx.Header("Trailer", "X-Skipped-Files")
+	ctx.Status(http.StatusOK)
+
+	res, err = writeArchive(ctx.Writer, format, files, userId, skipped)
+	ctx.Writer.Header().Set("X-Skipped-Files", fmt.Sprint(len(res.Skipped)))
+	return res, err
 }
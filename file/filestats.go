@@ -0,0 +1,141 @@
+package file
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 文件大小分布中的一个区间
+type SizeBucket struct {
+	Label string `json:"label"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// 某种扩展名的文件数量与大小
+type TypeCount struct {
+	Ext   string `json:"ext"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// 每日上传下载次数
+type DailyActivity struct {
+	Day           string `json:"day"`
+	Uploads       int64  `json:"uploads"`
+	UploadBytes   int64  `json:"upload_bytes"`
+	Downloads     int64  `json:"downloads"`
+	DownloadBytes int64  `json:"download_bytes"`
+}
+
+// 文件相关的汇总统计
+type FileStats struct {
+	Files          int64           `json:"total_files"`
+	Bytes          int64           `json:"bytes_stored"`
+	PhysicalBytes  int64           `json:"bytes_physical"`
+	Histogram      []SizeBucket    `json:"size_histogram"`
+	Types          []TypeCount     `json:"type_distribution"`
+	Daily          []DailyActivity `json:"daily_activity"`
+	DuplicateFiles int64           `json:"duplicate_files"`
+	DedupSavings   int64           `json:"dedup_savings"`
+}
+
+// 文件大小分布的区间上限,最后一个区间不设上限
+var sizeBuckets = []struct {
+	label string
+	upper int64
+}{
+	{"<1KB", 1 << 10},
+	{"1KB-1MB", 1 << 20},
+	{"1MB-10MB", 10 << 20},
+	{"10MB-100MB", 100 << 20},
+	{"100MB-1GB", 1 << 30},
+	{">=1GB", -1},
+}
+
+// 统计文件数量、大小分布、类型分布、最近days天的活动与重复文件
+func collectStats(days int, db *gorm.DB) (FileStats, error) {
+	var stats FileStats
+	err := db.Model(&File{}).Select("COUNT(*), COALESCE(SUM(file_consume), 0), COALESCE(SUM(stored_size), 0)").
+		Row().Scan(&stats.Files, &stats.Bytes, &stats.PhysicalBytes)
+	if err != nil {
+		return stats, err
+	}
+
+	// 大小分布
+	stats.Histogram = make([]SizeBucket, 0, len(sizeBuckets))
+	lower := int64(0)
+	for _, b := range sizeBuckets {
+		bucket := SizeBucket{Label: b.label}
+		tx := db.Model(&File{}).Where("file_consume >= ?", lower)
+		if b.upper > 0 {
+			tx = tx.Where("file_consume < ?", b.upper)
+		}
+		err = tx.Select("COUNT(*), COALESCE(SUM(file_consume), 0)").Row().Scan(&bucket.Count, &bucket.Bytes)
+		if err != nil {
+			return stats, err
+		}
+		stats.Histogram = append(stats.Histogram, bucket)
+		lower = b.upper
+	}
+
+	// 按扩展名的类型分布
+	stats.Types = make([]TypeCount, 0)
+	err = db.Model(&File{}).
+		Select("LOWER(IF(LOCATE('.', file_name) > 0, SUBSTRING_INDEX(file_name, '.', -1), '')) AS ext, COUNT(*) AS count, SUM(file_consume) AS bytes").
+		Group("ext").Order("count DESC").Limit(20).Scan(&stats.Types).Error
+	if err != nil {
+		return stats, err
+	}
+
+	// 每日上传下载次数
+	stats.Daily, err = dailyActivity(days, db)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.DuplicateFiles, stats.DedupSavings, err = duplicateSavings(db)
+	return stats, err
+}
+
+func dailyActivity(days int, db *gorm.DB) ([]DailyActivity, error) {
+	var rows []struct {
+		Day   string
+		Kind  string
+		Count int64
+		Bytes int64
+	}
+	since := time.Now().AddDate(0, 0, -days)
+	err := db.Model(&Activity{}).
+		Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS day, kind, COUNT(*) AS count, SUM(bytes) AS bytes").
+		Where("created_at >= ?", since).Group("day").Group("kind").Order("day").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]DailyActivity, 0)
+	for _, r := range rows {
+		if len(res) == 0 || res[len(res)-1].Day != r.Day {
+			res = append(res, DailyActivity{Day: r.Day})
+		}
+		d := &res[len(res)-1]
+		switch r.Kind {
+		case ActivityUpload:
+			d.Uploads, d.UploadBytes = r.Count, r.Bytes
+		case ActivityDownload:
+			d.Downloads, d.DownloadBytes = r.Count, r.Bytes
+		}
+	}
+	return res, nil
+}
+
+// 根据校验和计算内容完全相同的重复文件数量,以及去重后可以节省的空间
+func duplicateSavings(db *gorm.DB) (int64, int64, error) {
+	var count, saved int64
+	err := db.Table("(?) AS dup", db.Model(&File{}).
+		Select("COUNT(*) - 1 AS extra, (COUNT(*) - 1) * MAX(file_consume) AS saved").
+		Where("sha256 <> ''").Group("sha256").Having("COUNT(*) > 1")).
+		Select("COALESCE(SUM(extra), 0), COALESCE(SUM(saved), 0)").Row().Scan(&count, &saved)
+	return count, saved, err
+}
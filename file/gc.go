@@ -0,0 +1,107 @@
+package file
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 隔离孤儿文件的根目录
+const QuarantineRoot = "./quarantine"
+
+// 存储目录中没有对应文件记录的文件
+type OrphanBlob struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	MovedTo   string    `json:"moved_to,omitempty"`
+	Processed bool      `json:"processed"`
+}
+
+// 孤儿扫描结果
+type Orphans struct {
+	// 没有记录的磁盘文件
+	Blobs []OrphanBlob
+	// 磁盘文件不存在的记录
+	Records []File
+}
+
+// 扫描存储目录与文件表,找出两个方向上的孤儿
+//
+// 最近grace时间内修改的磁盘文件可能正在上传,不视为孤儿
+func ScanOrphans(grace time.Duration, db *gorm.DB) (Orphans, error) {
+	res := Orphans{Blobs: make([]OrphanBlob, 0), Records: make([]File, 0)}
+
+	var files []File
+	if err := db.Find(&files).Error; err != nil {
+		return res, err
+	}
+	known := make(map[string]bool, len(files))
+	for i := range files {
+		known[files[i].GetPath()] = true
+		if _, err := os.Stat(files[i].StoragePath()); os.IsNotExist(err) {
+			res.Records = append(res.Records, files[i])
+		}
+	}
+
+	deadline := time.Now().Add(-grace)
+	err := filepath.WalkDir(StorageRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == StorageRoot {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(StorageRoot, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if known[rel] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(deadline) {
+			return nil
+		}
+		res.Blobs = append(res.Blobs, OrphanBlob{Path: rel, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return res, err
+}
+
+// 将孤儿文件移动到隔离目录,返回隔离后的路径
+func QuarantineBlob(rel string) (string, error) {
+	dst := filepath.Join(QuarantineRoot, time.Now().Format("20060102"), filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return "", err
+	}
+	if err := os.Rename(filepath.Join(StorageRoot, filepath.FromSlash(rel)), dst); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(dst), nil
+}
+
+// 直接删除孤儿文件
+func DeleteBlob(rel string) error {
+	return os.Remove(filepath.Join(StorageRoot, filepath.FromSlash(rel)))
+}
+
+// 处理磁盘文件不存在的记录
+//
+// purge为false时软删除,记录仍可从数据库恢复;为true时彻底删除
+func DropRecord(f *File, purge bool, db *gorm.DB) error {
+	if purge {
+		db = db.Unscoped()
+	}
+	return db.Delete(f).Error
+}
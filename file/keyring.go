@@ -0,0 +1,182 @@
+package file
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主密钥长度,使用AES-256
+const masterKeySize = 32
+
+// 主密钥环,用于包装每个文件的数据密钥
+//
+// 可以持有多个主密钥以解包旧文件,新文件总是使用当前主密钥
+type Keyring struct {
+	lock   sync.RWMutex
+	keys   map[string][]byte
+	active string
+	// 密钥文件路径,为空时不支持生成新密钥
+	file string
+}
+
+// 当前使用的主密钥环,为nil时新文件不加密
+var keyring *Keyring
+
+// 设置主密钥环,为nil时关闭加密
+func UseKeyring(k *Keyring) {
+	keyring = k
+}
+
+// 解析base64或十六进制编码的主密钥
+func parseMasterKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != masterKeySize {
+		key, err = hex.DecodeString(s)
+	}
+	if err != nil || len(key) != masterKeySize {
+		return nil, errors.New("master key must be 32 bytes in base64 or hex")
+	}
+	return key, nil
+}
+
+// 使用单个主密钥创建密钥环,通常来自配置或环境变量
+func NewKeyring(id, encoded string) (*Keyring, error) {
+	key, err := parseMasterKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{keys: map[string][]byte{id: key}, active: id}, nil
+}
+
+// 从密钥文件加载密钥环,文件不存在时生成第一个主密钥
+//
+// 文件每行为"<id> <base64密钥>",最后一行为当前主密钥
+func LoadKeyring(path string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte), file: path}
+	fp, err := os.Open(path)
+	if os.IsNotExist(err) {
+		_, err = k.Generate()
+		return k, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	sc := bufio.NewScanner(fp)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			// 不输出行的内容,格式错误的行可能只有密钥
+			return nil, fmt.Errorf("invalid key file line %d, expected \"<id> <key>\"", lineNo)
+		}
+		key, err := parseMasterKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%v for key %v", err, fields[0])
+		}
+		k.keys[fields[0]] = key
+		k.active = fields[0]
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.active) == 0 {
+		return nil, errors.New("key file is empty")
+	}
+	return k, nil
+}
+
+// 生成新的主密钥,追加到密钥文件并设为当前主密钥
+func (k *Keyring) Generate() (string, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if len(k.file) == 0 {
+		return "", errors.New("key rotation requires a key file")
+	}
+
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	id := time.Now().Format("20060102T150405")
+	for i := 1; k.keys[id] != nil; i++ {
+		id = fmt.Sprintf("%v-%v", time.Now().Format("20060102T150405"), i)
+	}
+
+	fp, err := os.OpenFile(k.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	if _, err = fmt.Fprintf(fp, "%v %v\n", id, base64.StdEncoding.EncodeToString(key)); err != nil {
+		return "", err
+	}
+	k.keys[id] = key
+	k.active = id
+	return id, nil
+}
+
+// 当前主密钥的id
+func (k *Keyring) Active() string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.active
+}
+
+func (k *Keyring) aead(id string) (cipher.AEAD, error) {
+	k.lock.RLock()
+	key := k.keys[id]
+	k.lock.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("master key %v not found", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 使用当前主密钥包装数据密钥,返回主密钥id与包装后的密钥
+func (k *Keyring) Wrap(dataKey []byte) (string, string, error) {
+	id := k.Active()
+	gcm, err := k.aead(id)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, dataKey, []byte(id))
+	return id, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 使用指定的主密钥解包数据密钥
+func (k *Keyring) Unwrap(id, wrapped string) ([]byte, error) {
+	gcm, err := k.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	n := gcm.NonceSize()
+	return gcm.Open(nil, sealed[:n], sealed[n:], []byte(id))
+}
@@ -0,0 +1,130 @@
+package file
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, masterKeySize))
+	cases := []struct {
+		name    string
+		content string
+		active  string
+		err     string
+	}{
+		{"single key", "k1 " + key + "\n", "k1", ""},
+		{"last key is active", "# keys\nk1 " + key + "\n\nk2 " + key + "\n", "k2", ""},
+		{"hex key", "k1 " + strings.Repeat("ab", masterKeySize) + "\n", "k1", ""},
+		{"empty", "# nothing\n", "", "key file is empty"},
+		{"missing id", "# keys\n" + key + "\n", "", "invalid key file line 2"},
+		{"short key", "k1 c2hvcnQ=\n", "", "master key must be 32 bytes"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(c.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			k, err := LoadKeyring(path)
+			if len(c.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("err = %v, want %q", err, c.err)
+				}
+				// 错误信息中不能出现密钥
+				if strings.Contains(err.Error(), key) {
+					t.Fatalf("error leaks key: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Active() != c.active {
+				t.Fatalf("active = %v, want %v", k.Active(), c.active)
+			}
+		})
+	}
+}
+
+func TestKeyringGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	k, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("key file mode = %v", info.Mode().Perm())
+	}
+	first := k.Active()
+	id, wrapped, err := k.Wrap([]byte("data key"))
+	if err != nil || id != first {
+		t.Fatalf("wrap = %v, %v", id, err)
+	}
+
+	second, err := k.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || k.Active() != second {
+		t.Fatalf("active = %v after generating %v", k.Active(), second)
+	}
+
+	// 重新加载后两个主密钥都可用,旧文件的数据密钥仍能解包
+	k, err = LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Active() != second {
+		t.Fatalf("active = %v, want %v", k.Active(), second)
+	}
+	dataKey, err := k.Unwrap(first, wrapped)
+	if err != nil || string(dataKey) != "data key" {
+		t.Fatalf("unwrap = %q, %v", dataKey, err)
+	}
+}
+
+func TestKeyringUnwrap(t *testing.T) {
+	k, err := NewKeyring("k1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, masterKeySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, wrapped, err := k.Wrap([]byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(wrapped)
+	sealed[len(sealed)-1] ^= 1
+	other, _ := NewKeyring("k1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, masterKeySize)))
+
+	cases := []struct {
+		name    string
+		k       *Keyring
+		id      string
+		wrapped string
+	}{
+		{"unknown id", k, "k2", wrapped},
+		{"tampered", k, id, base64.StdEncoding.EncodeToString(sealed)},
+		{"not base64", k, id, "!!"},
+		{"too short", k, id, "AAAA"},
+		{"other master key", other, id, wrapped},
+	}
+	for _, c := range cases {
+		if _, err := c.k.Unwrap(c.id, c.wrapped); err == nil {
+			t.Errorf("%v: unwrap succeeded", c.name)
+		}
+	}
+	// 主密钥id是附加数据,不能把包装后的密钥挪到另一个id下
+	moved := &Keyring{keys: map[string][]byte{"k2": k.keys["k1"]}, active: "k2"}
+	if _, err := moved.Unwrap("k2", wrapped); err == nil {
+		t.Error("unwrap under another id succeeded")
+	}
+}
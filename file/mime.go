@@ -0,0 +1,162 @@
+package file
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 文件类别,用于列表中的图标与过滤
+const (
+	CategoryImage    = "image"
+	CategoryVideo    = "video"
+	CategoryAudio    = "audio"
+	CategoryDocument = "document"
+	CategoryArchive  = "archive"
+	CategoryCode     = "code"
+	CategoryOther    = "other"
+)
+
+// 按扩展名判断的类别,优先于MIME类型
+var extCategories = map[string]string{
+	"zip": CategoryArchive, "gz": CategoryArchive, "tgz": CategoryArchive, "bz2": CategoryArchive,
+	"xz": CategoryArchive, "7z": CategoryArchive, "rar": CategoryArchive, "tar": CategoryArchive, "zst": CategoryArchive,
+	"pdf": CategoryDocument, "doc": CategoryDocument, "docx": CategoryDocument, "xls": CategoryDocument,
+	"xlsx": CategoryDocument, "ppt": CategoryDocument, "pptx": CategoryDocument, "odt": CategoryDocument,
+	"txt": CategoryDocument, "md": CategoryDocument, "rtf": CategoryDocument, "csv": CategoryDocument,
+	"go": CategoryCode, "c": CategoryCode, "h": CategoryCode, "cpp": CategoryCode, "hpp": CategoryCode,
+	"java": CategoryCode, "py": CategoryCode, "js": CategoryCode, "ts": CategoryCode, "rs": CategoryCode,
+	"rb": CategoryCode, "php": CategoryCode, "sh": CategoryCode, "sql": CategoryCode, "html": CategoryCode,
+	"css": CategoryCode, "json": CategoryCode, "xml": CategoryCode, "yaml": CategoryCode, "yml": CategoryCode,
+	"toml": CategoryCode, "kt": CategoryCode, "swift": CategoryCode, "cs": CategoryCode, "lua": CategoryCode,
+}
+
+// 内容嗅探结果过于笼统时,改用扩展名对应的MIME类型
+var extMimes = map[string]string{
+	"md": "text/markdown; charset=utf-8", "go": "text/x-go; charset=utf-8", "py": "text/x-python; charset=utf-8",
+	"java": "text/x-java; charset=utf-8", "c": "text/x-c; charset=utf-8", "cpp": "text/x-c++; charset=utf-8",
+	"rs": "text/x-rust; charset=utf-8", "sh": "text/x-shellscript; charset=utf-8", "yaml": "text/yaml; charset=utf-8",
+	"yml": "text/yaml; charset=utf-8", "toml": "text/x-toml; charset=utf-8", "sql": "text/x-sql; charset=utf-8",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+
+	"apk": "application/vnd.android.package-archive", "jar": "application/java-archive",
+	"7z": "application/x-7z-compressed", "rar": "application/vnd.rar", "tgz": "application/gzip",
+}
+
+// 浏览器会执行脚本的类型,存储与下载时都改为纯文本,避免上传的文件在站点下执行
+var activeMimes = map[string]bool{
+	"text/html": true, "application/xhtml+xml": true, "image/svg+xml": true,
+	"text/xml": true, "application/xml": true, "text/xsl": true,
+	"text/javascript": true, "application/javascript": true, "application/x-javascript": true,
+}
+
+// 可以在浏览器中直接打开的类型,其他类型下载时作为附件
+var inlineMimes = map[string]bool{
+	"text/plain": true,
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+	"video/mp4": true, "video/webm": true, "audio/mpeg": true, "audio/ogg": true, "audio/wav": true,
+}
+
+// 脚本类型替换为纯文本,其他类型原样返回
+func safeMime(t string) string {
+	if base, _, _ := mime.ParseMediaType(t); activeMimes[base] {
+		return "text/plain; charset=utf-8"
+	}
+	return t
+}
+
+// 下载时使用的Content-Type,以及是否可以在浏览器中直接打开
+//
+// 旧记录中可能保存了脚本类型,下载时同样替换为纯文本
+func ServeType(f *File) (string, bool) {
+	t := f.Mime
+	if len(t) == 0 {
+		t = "application/octet-stream"
+	}
+	t = safeMime(t)
+	base, _, _ := mime.ParseMediaType(t)
+	return t, inlineMimes[base]
+}
+
+// 根据扩展名获取MIME类型
+func extMime(ext string) string {
+	if t, ok := extMimes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension("." + ext)
+}
+
+// 根据文件内容与文件名判断MIME类型、扩展名与类别
+//
+// 以内容嗅探为准;嗅探结果为通用类型(二进制、纯文本、zip)时,使用扩展名对应的更具体的类型;
+// HTML、SVG、XML等脚本类型记录为纯文本
+func DetectType(name string, data []byte) (string, string, string) {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	t := http.DetectContentType(data)
+	switch base, _, _ := mime.ParseMediaType(t); base {
+	case "application/octet-stream", "text/plain", "application/zip":
+		if e := extMime(ext); len(e) > 0 {
+			t = e
+		}
+	}
+	t = safeMime(t)
+	return t, ext, category(t, ext)
+}
+
+// 根据扩展名判断类型,用于无法读取内容的旧文件
+func guessType(name string) (string, string, string) {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	t := extMime(ext)
+	if len(t) == 0 {
+		t = "application/octet-stream"
+	}
+	t = safeMime(t)
+	return t, ext, category(t, ext)
+}
+
+func category(mimeType, ext string) string {
+	if c, ok := extCategories[ext]; ok {
+		return c
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return CategoryImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return CategoryVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return CategoryAudio
+	case strings.HasPrefix(mimeType, "text/"):
+		return CategoryDocument
+	}
+	return CategoryOther
+}
+
+// 设置文件的MIME类型、扩展名与类别
+func (f *File) setType(mimeType, ext, cat string) {
+	f.Mime, f.Ext, f.Category = mimeType, ext, cat
+}
+
+// 为没有类型信息的旧文件按扩展名回填类型
+func backfillTypes(db *gorm.DB) error {
+	files := make([]File, 0)
+	if err := db.Select("id", "file_path").Where("mime_type = ?", "").Find(&files).Error; err != nil {
+		return err
+	}
+	for _, f := range files {
+		t, ext, cat := guessType(f.Path)
+		err := db.Model(&File{}).Where("id = ?", f.ID).Updates(map[string]interface{}{
+			"mime_type":     t,
+			"file_ext":      ext,
+			"file_category": cat,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
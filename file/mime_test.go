@@ -0,0 +1,54 @@
+package file
+
+import "testing"
+
+func TestDetectType(t *testing.T) {
+	html := []byte("<!DOCTYPE html><html><script>alert(1)</script></html>")
+	svg := []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	cases := []struct {
+		name string
+		data []byte
+		mime string
+		cat  string
+	}{
+		{"notes.txt", []byte("hello"), "text/plain; charset=utf-8", CategoryDocument},
+		{"readme.md", []byte("# title"), "text/markdown; charset=utf-8", CategoryDocument},
+		{"page.txt", html, "text/plain; charset=utf-8", CategoryDocument},
+		{"page.md", html, "text/plain; charset=utf-8", CategoryDocument},
+		{"page.html", html, "text/plain; charset=utf-8", CategoryCode},
+		{"logo.svg", svg, "text/plain; charset=utf-8", CategoryDocument},
+		{"data.xml", []byte(`<?xml version="1.0"?><a/>`), "text/plain; charset=utf-8", CategoryCode},
+		{"photo.png", png, "image/png", CategoryImage},
+	}
+	for _, c := range cases {
+		mime, _, cat := DetectType(c.name, c.data)
+		if mime != c.mime || cat != c.cat {
+			t.Errorf("DetectType(%q) = %q, %q, want %q, %q", c.name, mime, cat, c.mime, c.cat)
+		}
+	}
+}
+
+func TestServeType(t *testing.T) {
+	cases := []struct {
+		mime   string
+		want   string
+		inline bool
+	}{
+		{"", "application/octet-stream", false},
+		{"image/png", "image/png", true},
+		{"text/plain; charset=utf-8", "text/plain; charset=utf-8", true},
+		{"application/pdf", "application/pdf", false},
+		{"text/markdown; charset=utf-8", "text/markdown; charset=utf-8", false},
+		// 修复前保存的记录
+		{"text/html; charset=utf-8", "text/plain; charset=utf-8", true},
+		{"image/svg+xml", "text/plain; charset=utf-8", true},
+		{"application/xhtml+xml", "text/plain; charset=utf-8", true},
+	}
+	for _, c := range cases {
+		got, inline := ServeType(&File{Mime: c.mime})
+		if got != c.want || inline != c.inline {
+			t.Errorf("ServeType(%q) = %q, %v, want %q, %v", c.mime, got, inline, c.want, c.inline)
+		}
+	}
+}
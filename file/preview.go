@@ -0,0 +1,163 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// 预览读取的默认与最大字节数
+const (
+	DefaultPreviewBytes = 64 << 10
+	MaxPreviewBytes     = 1 << 20
+)
+
+// 不是文本文件,无法预览
+var ErrNotText = newError(ErrInvalid, "file is not text")
+
+// 文本预览结果
+type Preview struct {
+	// 原始编码:utf-8、utf-16le、utf-16be或gb18030
+	Encoding string `json:"encoding"`
+	// 语法高亮使用的语言,无法判断时为空
+	Language string `json:"language"`
+	// 文件原始大小,以及是否只返回了开头部分
+	Size      int64 `json:"size"`
+	Truncated bool  `json:"truncated"`
+	// 转换为UTF-8的文本
+	Content string `json:"content"`
+	// Markdown文件渲染并清理后的HTML
+	HTML string `json:"html,omitempty"`
+}
+
+// 扩展名对应的语言,用于前端语法高亮
+var extLanguages = map[string]string{
+	"go": "go", "c": "c", "h": "c", "cpp": "cpp", "hpp": "cpp", "cc": "cpp", "java": "java",
+	"py": "python", "js": "javascript", "ts": "typescript", "rs": "rust", "rb": "ruby", "php": "php",
+	"sh": "bash", "sql": "sql", "html": "html", "css": "css", "json": "json", "xml": "xml",
+	"yaml": "yaml", "yml": "yaml", "toml": "toml", "kt": "kotlin", "swift": "swift", "cs": "csharp",
+	"lua": "lua", "md": "markdown", "ini": "ini", "diff": "diff", "patch": "diff",
+}
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+	// 清理渲染结果,去除脚本、事件属性与危险链接
+	markdownPolicy = bluemonday.UGCPolicy()
+)
+
+// 判断数据的编码并转换为UTF-8
+//
+// 依次检查BOM、UTF-8与GB18030;包含NUL字符或无法解码时认为不是文本
+func decodeText(data []byte, truncated bool) (string, string, error) {
+	var enc encoding.Encoding
+	name := "utf-8"
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		data = data[3:]
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		enc, name = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		enc, name = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16be"
+	default:
+		if bytes.IndexByte(data, 0) >= 0 {
+			return "", "", ErrNotText
+		}
+		if !utf8.Valid(trimPartial(data, truncated, utf8Partial)) {
+			enc, name = simplifiedchinese.GB18030, "gb18030"
+		}
+	}
+	if enc == nil {
+		return string(trimPartial(data, truncated, utf8Partial)), name, nil
+	}
+
+	if name == "gb18030" {
+		data = trimPartial(data, truncated, gbPartial)
+	} else if truncated && len(data)%2 == 1 {
+		data = data[:len(data)-1]
+	}
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", "", ErrNotText
+	}
+	// GB18030解码不会失败,用替换字符的数量判断是否为乱码
+	if name == "gb18030" && bytes.Count(text, []byte("�")) > len(text)/100 {
+		return "", "", ErrNotText
+	}
+	return string(text), name, nil
+}
+
+// 截断的数据末尾可能是不完整的字符,去掉后再判断
+func trimPartial(data []byte, truncated bool, partial func([]byte) int) []byte {
+	if !truncated {
+		return data
+	}
+	return data[:len(data)-partial(data)]
+}
+
+// 末尾不完整的UTF-8字符的字节数
+func utf8Partial(data []byte) int {
+	for i := 1; i <= 3 && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if b < 0x80 {
+			return 0
+		}
+		if b >= 0xc0 {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// 末尾不完整的GB18030字符的字节数,只处理双字节字符的首字节
+func gbPartial(data []byte) int {
+	n := 0
+	for i := len(data) - 1; i >= 0 && data[i] >= 0x81; i-- {
+		n++
+	}
+	return n % 2
+}
+
+// 读取文本文件的开头部分用于预览
+func previewFile(f *File, userId string, limit int) (Preview, error) {
+	var res Preview
+	if !f.Accessible(userId) {
+		return res, ErrNotTarget
+	}
+	if limit <= 0 || limit > MaxPreviewBytes {
+		return res, ErrInvalidLimit
+	}
+	c, err := OpenContent(f)
+	if err != nil {
+		return res, err
+	}
+	defer c.Close()
+	data, err := io.ReadAll(io.LimitReader(c, int64(limit)))
+	if err != nil {
+		return res, err
+	}
+
+	res.Size = c.Size()
+	res.Truncated = int64(len(data)) < res.Size
+	res.Language = extLanguages[f.GetExt()]
+	if res.Content, res.Encoding, err = decodeText(data, res.Truncated); err != nil {
+		return res, err
+	}
+	if res.Language == "markdown" {
+		var buf bytes.Buffer
+		if err = markdown.Convert([]byte(res.Content), &buf); err != nil {
+			return res, err
+		}
+		res.HTML = markdownPolicy.Sanitize(buf.String())
+	}
+	return res, nil
+}
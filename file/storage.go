@@ -0,0 +1,162 @@
+package file
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// 文件内容的读取器
+type Content interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	// 文件内容的原始大小
+	Size() int64
+}
+
+// 原样存储文件的读取器
+type rawContent struct {
+	*os.File
+	size int64
+}
+
+func (c *rawContent) Size() int64 {
+	return c.size
+}
+
+// 将文件内容写入存储路径,配置了主密钥时加密存储,开启压缩时压缩可压缩的文件
+//
+// 从r中读取size字节写入临时文件,check返回nil后再链接到存储路径,避免中途失败或校验失败留下不完整的文件;
+// 存储路径已存在时返回ErrFileExisted,不会覆盖同时上传的同名文件;存储格式、密钥信息与实际占用空间记录在f上
+func writeContent(f *File, r io.Reader, size int64, compress bool, check func() error) error {
+	path := f.StoragePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	fp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := fp.Name()
+
+	f.Format, f.KeyID, f.WrappedKey = FormatRaw, "", ""
+	var dataKey []byte
+	if keyring != nil {
+		dataKey = make([]byte, 32)
+		if _, err = rand.Read(dataKey); err == nil {
+			f.KeyID, f.WrappedKey, err = keyring.Wrap(dataKey)
+		}
+	}
+	if err == nil {
+		if dataKey != nil || compress {
+			f.Format = FormatBlob
+			err = writeBlob(fp, r, size, dataKey, compress)
+		} else if _, err = io.CopyN(fp, r, size); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err == nil {
+		err = check()
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = fp.Stat()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// 链接在目标已存在时失败,与O_EXCL一样保证只有一个上传者写入
+		if err = os.Link(tmp, path); os.IsExist(err) {
+			err = ErrFileExisted
+		}
+	}
+	os.Remove(tmp)
+	if err != nil {
+		return err
+	}
+	f.Stored = info.Size()
+	return nil
+}
+
+// 打开文件内容,加密或压缩存储的文件会透明解密、解压
+func OpenContent(f *File) (Content, error) {
+	if f.Format == FormatRaw {
+		fp, err := os.Open(f.StoragePath())
+		if err != nil {
+			return nil, err
+		}
+		info, err := fp.Stat()
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		return &rawContent{File: fp, size: info.Size()}, nil
+	}
+
+	var dataKey []byte
+	if len(f.KeyID) > 0 {
+		if keyring == nil {
+			return nil, ErrNoMasterKey
+		}
+		var err error
+		if dataKey, err = keyring.Unwrap(f.KeyID, f.WrappedKey); err != nil {
+			return nil, err
+		}
+	}
+	return openBlob(f.StoragePath(), dataKey)
+}
+
+// 读取文件在磁盘上的实际占用空间
+func StoredSize(f *File) (int64, error) {
+	info, err := os.Stat(f.StoragePath())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// 读取文件内容的原始大小
+func ContentSize(f *File) (int64, error) {
+	c, err := OpenContent(f)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	return c.Size(), nil
+}
+
+// 使用当前主密钥重新包装文件的数据密钥,不重新加密文件内容
+//
+// 返回新的主密钥id与包装后的数据密钥,文件已使用当前主密钥时不做修改
+func rewrapKey(f File, db *gorm.DB) (string, string, error) {
+	if len(f.KeyID) == 0 {
+		return "", "", nil
+	}
+	if keyring == nil {
+		return "", "", ErrNoMasterKey
+	}
+	if f.KeyID == keyring.Active() {
+		return f.KeyID, f.WrappedKey, nil
+	}
+	dataKey, err := keyring.Unwrap(f.KeyID, f.WrappedKey)
+	if err != nil {
+		return "", "", err
+	}
+	id, wrapped, err := keyring.Wrap(dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	err = db.Model(&File{}).Where("id = ?", f.ID).Updates(map[string]interface{}{
+		"key_id":      id,
+		"wrapped_key": wrapped,
+	}).Error
+	return id, wrapped, err
+}
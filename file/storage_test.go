@@ -0,0 +1,256 @@
+package file
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// 在临时目录中运行,存储目录等相对路径都位于其中
+func useTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// 只生成SQL不连接数据库的会话,用于不关心数据库结果的测试
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "test@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// 使用临时密钥文件中的主密钥环
+func useTestKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	k, err := LoadKeyring(filepath.Join(t.TempDir(), "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseKeyring(k)
+	t.Cleanup(func() { UseKeyring(nil) })
+	return k
+}
+
+// 保存内存中的数据
+func saveBytes(userId, name string, data []byte, db *gorm.DB) (*File, error) {
+	return saveFile(userId, name, bytes.NewReader(data), int64(len(data)), db)
+}
+
+func readContent(t *testing.T, f *File) []byte {
+	t.Helper()
+	c, err := OpenContent(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	data, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRewrapKey(t *testing.T) {
+	useTempDir(t)
+	db := dryRunDB(t)
+	k := useTestKeyring(t)
+	data := bytes.Repeat([]byte("secret "), 20000)
+	f, err := saveBytes("alice", "a.txt", data, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != FormatBlob || f.KeyID != k.Active() {
+		t.Fatalf("format = %q, key = %q", f.Format, f.KeyID)
+	}
+
+	// 已使用当前主密钥时不修改
+	id, wrapped, err := rewrapKey(*f, db)
+	if err != nil || id != f.KeyID || wrapped != f.WrappedKey {
+		t.Fatalf("rewrap with active key = %v, %v", id, err)
+	}
+
+	active, err := k.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, wrapped, err = rewrapKey(*f, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != active || wrapped == f.WrappedKey {
+		t.Fatalf("rewrap = %v, want %v", id, active)
+	}
+	// 文件内容不重新加密,新包装的数据密钥可以解密
+	stored, _ := os.ReadFile(f.StoragePath())
+	f.KeyID, f.WrappedKey = id, wrapped
+	if !bytes.Equal(readContent(t, f), data) {
+		t.Fatal("content differs after rewrap")
+	}
+	if after, _ := os.ReadFile(f.StoragePath()); !bytes.Equal(stored, after) {
+		t.Fatal("rewrap rewrote the blob")
+	}
+
+	// 没有主密钥时无法重新包装
+	UseKeyring(nil)
+	if _, _, err = rewrapKey(*f, db); err != ErrNoMasterKey {
+		t.Fatalf("err = %v, want ErrNoMasterKey", err)
+	}
+}
+
+func TestScrubDetectsTamper(t *testing.T) {
+	data := bytes.Repeat([]byte("scrub me "), 20000)
+	cases := []struct {
+		name    string
+		encrypt bool
+		tamper  func(path string) error
+		corrupt bool
+	}{
+		{"raw intact", false, nil, false},
+		{"encrypted intact", true, nil, false},
+		{"raw modified", false, flipByte(1000), true},
+		{"encrypted chunk modified", true, flipByte(blobHeaderSize + 1000), true},
+		{"encrypted header modified", true, flipByte(20), true},
+		{"encrypted truncated", true, func(path string) error {
+			return os.Truncate(path, 1000)
+		}, true},
+		{"encrypted replaced", true, func(path string) error {
+			return os.WriteFile(path, data, 0600)
+		}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			useTempDir(t)
+			db := dryRunDB(t)
+			if c.encrypt {
+				useTestKeyring(t)
+			}
+			f, err := saveBytes("alice", "a.txt", data, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.tamper != nil {
+				if err = c.tamper(f.StoragePath()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res, err := scrub(*f, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Corrupt != c.corrupt {
+				t.Fatalf("corrupt = %v, want %v", res.Corrupt, c.corrupt)
+			}
+			if res.SHA256 != f.SHA256 {
+				t.Fatal("scrub changed the recorded checksum")
+			}
+		})
+	}
+}
+
+// 修改文件中第off个字节
+func flipByte(off int64) func(string) error {
+	return func(path string) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		b[off] ^= 1
+		return os.WriteFile(path, b, 0600)
+	}
+}
+
+func TestScrubMissingFile(t *testing.T) {
+	useTempDir(t)
+	db := dryRunDB(t)
+	f, err := saveBytes("alice", "a.txt", []byte("hello"), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(f.StoragePath())
+	// 文件缺失由垃圾回收处理,不视为损坏
+	if _, err = scrub(*f, db); !os.IsNotExist(err) {
+		t.Fatalf("err = %v, want not exist", err)
+	}
+}
+
+func TestStoreFile(t *testing.T) {
+	data := bytes.Repeat([]byte("stream "), 30000)
+	mismatch := func(Checksum) error { return ErrChecksumMismatch }
+	cases := []struct {
+		name     string
+		size     int64
+		verify   func(Checksum) error
+		encrypt  bool
+		compress bool
+		err      error
+	}{
+		{"raw", int64(len(data)), nil, false, false, nil},
+		{"compressed", int64(len(data)), nil, false, true, nil},
+		{"encrypted", int64(len(data)), nil, true, false, nil},
+		{"shorter than declared", int64(len(data)) + 1, nil, false, false, io.ErrUnexpectedEOF},
+		{"encrypted shorter than declared", int64(len(data)) + 1, nil, true, false, io.ErrUnexpectedEOF},
+		{"longer than declared", int64(len(data)) - 1, nil, false, false, ErrInvalid},
+		{"checksum mismatch", int64(len(data)), mismatch, true, true, ErrChecksumMismatch},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			useTempDir(t)
+			db := dryRunDB(t)
+			if c.encrypt {
+				useTestKeyring(t)
+			}
+			UseCompression(c.compress)
+			defer UseCompression(false)
+
+			f, err := storeFile("alice", "a.txt", bytes.NewReader(data), c.size, c.verify, db)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+				// 失败时不留下文件或临时文件
+				if entries, _ := os.ReadDir(StorageRoot + "/alice"); len(entries) > 0 {
+					t.Fatalf("left %v in storage", entries[0].Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := c.encrypt || c.compress; (f.Format == FormatBlob) != want {
+				t.Fatalf("format = %q", f.Format)
+			}
+			if f.SHA256 != hex.EncodeToString(ComputeChecksum(data).SHA256) || f.Consume != int64(len(data)) {
+				t.Fatalf("sha256 = %v, consume = %v", f.SHA256, f.Consume)
+			}
+			if !bytes.Equal(readContent(t, f), data) {
+				t.Fatal("content differs")
+			}
+
+			// 同名文件已存在时不覆盖
+			if _, err = saveBytes("alice", "a.txt", []byte("other"), db); !errors.Is(err, ErrFileExisted) {
+				t.Fatalf("err = %v, want ErrFileExisted", err)
+			}
+			if !bytes.Equal(readContent(t, f), data) {
+				t.Fatal("existing file overwritten")
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// 缩略图存储的根目录,按文件id分目录,不计入用户配额
+const ThumbnailRoot = "./thumbnails"
+
+// 缩略图尺寸与对应的最长边像素数
+var ThumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 320,
+	"large":  1024,
+}
+
+// 生成缩略图的限制,超过时不生成
+const (
+	maxThumbnailSource = 50 << 20
+	maxThumbnailPixels = 40000000
+)
+
+// 不支持生成缩略图的文件
+var ErrNoThumbnail = newError(ErrInvalid, "thumbnail not supported for this file")
+
+// 判断文件是否可以生成缩略图
+func Thumbnailable(f *File) bool {
+	switch strings.SplitN(f.Mime, ";", 2)[0] {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return f.Consume <= maxThumbnailSource
+	}
+	return false
+}
+
+// 缩略图的存储路径
+func thumbnailPath(f *File, size string) string {
+	return filepath.Join(ThumbnailRoot, fmt.Sprint(f.ID), size+".jpg")
+}
+
+// 生成文件所有尺寸的缩略图
+//
+// 文件加密存储时,缩略图使用文件自己的数据密钥加密
+func GenerateThumbnails(f File) error {
+	if !Thumbnailable(&f) {
+		return ErrNoThumbnail
+	}
+	c, err := OpenContent(&f)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(c)
+	c.Close()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return errors.New("image too large")
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := thumbnailKey(&f)
+	if err != nil {
+		return err
+	}
+	for size, max := range ThumbnailSizes {
+		if err = writeThumbnail(thumbnailPath(&f, size), scaleImage(src, max), dataKey); err != nil {
+			return fmt.Errorf("%v when writing %v thumbnail", err, size)
+		}
+	}
+	return nil
+}
+
+// 缩放图片使最长边不超过max,透明部分填充白色
+func scaleImage(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > max || h > max {
+		if w >= h {
+			w, h = max, h*max/w
+		} else {
+			w, h = w*max/h, max
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// 获取加密缩略图使用的数据密钥,文件未加密时返回nil
+func thumbnailKey(f *File) ([]byte, error) {
+	if len(f.KeyID) == 0 {
+		return nil, nil
+	}
+	if keyring == nil {
+		return nil, ErrNoMasterKey
+	}
+	return keyring.Unwrap(f.KeyID, f.WrappedKey)
+}
+
+func writeThumbnail(path string, img image.Image, dataKey []byte) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	// 上传后的后台生成与按需生成可能同时进行,临时文件名不能相同
+	fp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := fp.Name()
+	if dataKey != nil {
+		err = writeBlob(fp, &buf, int64(buf.Len()), dataKey, false)
+	} else {
+		_, err = fp.Write(buf.Bytes())
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// 打开缩略图,不存在时返回os.ErrNotExist
+func openThumbnail(f *File, size string) (io.ReadSeekCloser, error) {
+	path := thumbnailPath(f, size)
+	dataKey, err := thumbnailKey(f)
+	if err != nil {
+		return nil, err
+	}
+	if dataKey == nil {
+		return os.Open(path)
+	}
+	if _, err = os.Stat(path); err != nil {
+		return nil, err
+	}
+	return openBlob(path, dataKey)
+}
+
+// 删除文件的所有缩略图
+func RemoveThumbnails(f *File) error {
+	return os.RemoveAll(filepath.Join(ThumbnailRoot, fmt.Sprint(f.ID)))
+}
+
+// 返回文件的缩略图,缩略图尚未生成时先生成
+func serveThumbnail(f *File, userId, size string, ctx *gin.Context) error {
+	if !f.Accessible(userId) {
+		return ErrNotTarget
+	}
+	if _, ok := ThumbnailSizes[size]; !ok {
+		return invalid("invalid size")
+	}
+	if !Thumbnailable(f) {
+		return ErrNoThumbnail
+	}
+	r, err := openThumbnail(f, size)
+	if os.IsNotExist(err) {
+		if err = GenerateThumbnails(*f); err == nil {
+			r, err = openThumbnail(f, size)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	ctx.Header("Content-Type", "image/jpeg")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Cache-Control", "private, max-age=86400")
+	http.ServeContent(ctx.Writer, ctx.Request, size+".jpg", f.UpdatedAt, r)
+	return nil
+}
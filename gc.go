@@ -0,0 +1,138 @@
+package main
+
+import (
+	"file"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 垃圾回收的处理方式
+const (
+	gcReport     = "report"
+	gcQuarantine = "quarantine"
+	gcDelete     = "delete"
+)
+
+// 最近修改的磁盘文件可能仍在上传,扫描时跳过
+const gcGrace = 10 * time.Minute
+
+type GCMsg struct {
+	Action string `json:"action" binding:"omitempty,oneof=report quarantine delete"`
+}
+
+// 磁盘文件不存在的记录
+type DanglingRecord struct {
+	Path      string `json:"path"`
+	Uploader  string `json:"uploader"`
+	Size      int64  `json:"size"`
+	Processed bool   `json:"processed"`
+}
+
+type GCReport struct {
+	Action          string            `json:"action"`
+	OrphanBlobs     []file.OrphanBlob `json:"orphan_blobs"`
+	DanglingRecords []DanglingRecord  `json:"dangling_records"`
+	Errors          []string          `json:"errors"`
+}
+
+// 扫描并处理孤儿文件与悬空记录
+//
+// report只报告;quarantine将孤儿文件移入隔离目录并软删除悬空记录;delete彻底删除两者
+func collectGarbage(action string) (GCReport, error) {
+	report := GCReport{Action: action, Errors: make([]string, 0)}
+	switch action {
+	case gcReport, gcQuarantine, gcDelete:
+	default:
+		return report, invalidParam("invalid action")
+	}
+
+	// 持有文件锁,防止扫描期间文件被删除或更新
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	orphans, err := file.ScanOrphans(gcGrace, db)
+	if err != nil {
+		return report, err
+	}
+	report.OrphanBlobs = orphans.Blobs
+	report.DanglingRecords = make([]DanglingRecord, 0, len(orphans.Records))
+	for i := range orphans.Records {
+		f := &orphans.Records[i]
+		report.DanglingRecords = append(report.DanglingRecords, DanglingRecord{Path: f.GetPath(), Uploader: f.GetUploader(), Size: f.GetConsume()})
+	}
+	if action == gcReport {
+		return report, nil
+	}
+
+	for i := range report.OrphanBlobs {
+		b := &report.OrphanBlobs[i]
+		if action == gcQuarantine {
+			b.MovedTo, err = file.QuarantineBlob(b.Path)
+		} else {
+			err = file.DeleteBlob(b.Path)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%v: %v", b.Path, err))
+			continue
+		}
+		b.Processed = true
+	}
+
+	for i := range orphans.Records {
+		f := &orphans.Records[i]
+		if err = file.DropRecord(f, action == gcDelete, db); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%v: %v", f.GetPath(), err))
+			continue
+		}
+		forgetFile(f)
+		report.DanglingRecords[i].Processed = true
+	}
+	return report, nil
+}
+
+// 定期执行垃圾回收
+func gcLoop(interval time.Duration, action string) {
+	for range time.Tick(interval) {
+		report, err := collectGarbage(action)
+		if err != nil {
+			slog.Error("collect garbage failed", "err", err)
+			continue
+		}
+		if len(report.OrphanBlobs) > 0 || len(report.DanglingRecords) > 0 {
+			slog.Info("garbage collected", "action", action, "orphan_blobs", len(report.OrphanBlobs),
+				"dangling_records", len(report.DanglingRecords), "errors", len(report.Errors))
+		}
+	}
+}
+
+// 管理员执行垃圾回收
+//
+// 输入:Json{"action"},action为report/quarantine/delete,默认quarantine
+//
+// 输出:Json{"status", "report"}
+func ManagerGCHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg GCMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if len(msg.Action) == 0 {
+			msg.Action = gcQuarantine
+		}
+
+		report, err := collectGarbage(msg.Action)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"report": report,
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"file"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	cases := []struct {
+		name   string
+		action string
+		failOn string
+		err    error
+		// 孤儿文件与悬空记录是否已处理
+		processed bool
+		// 删除记录的语句
+		drop string
+	}{
+		{"report", gcReport, "", nil, false, ""},
+		{"quarantine", gcQuarantine, "", nil, true, "UPDATE `files` SET `deleted_at`"},
+		{"delete", gcDelete, "", nil, true, "DELETE FROM `files`"},
+		{"drop record failed", gcQuarantine, "UPDATE `files`", nil, false, ""},
+		{"invalid action", "purge", "", errInvalidParam, false, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := useTestServer(t)
+			alice := addTestUser("alice", 1<<20)
+			kept := addTestFile(t, alice, 1, "kept.txt", "kept")
+			dangling := addTestFile(t, alice, 2, "gone.txt", "gone")
+			if err := os.Remove(dangling.StoragePath()); err != nil {
+				t.Fatal(err)
+			}
+			fake.files = append(fake.files, *kept, *dangling)
+
+			// 较早的孤儿文件会被回收,最近写入的可能正在上传,不视为孤儿
+			orphan := filepath.Join(file.StorageRoot, "alice", "orphan.bin")
+			fresh := filepath.Join(file.StorageRoot, "alice", "uploading.bin")
+			for _, p := range []string{orphan, fresh} {
+				if err := os.WriteFile(p, []byte("orphan"), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			old := time.Now().Add(-2 * gcGrace)
+			if err := os.Chtimes(orphan, old, old); err != nil {
+				t.Fatal(err)
+			}
+			fake.failOn = c.failOn
+
+			report, err := collectGarbage(c.action)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.OrphanBlobs) != 1 || report.OrphanBlobs[0].Path != "alice/orphan.bin" {
+				t.Fatalf("orphan blobs = %+v", report.OrphanBlobs)
+			}
+			if len(report.DanglingRecords) != 1 || report.DanglingRecords[0].Path != "alice/gone.txt" {
+				t.Fatalf("dangling records = %+v", report.DanglingRecords)
+			}
+			if blobContent(kept.StoragePath()) != "kept" || blobContent(fresh) != "orphan" {
+				t.Fatal("live or fresh blob touched")
+			}
+
+			if c.action != gcReport && !report.OrphanBlobs[0].Processed {
+				t.Fatalf("orphan blob not processed: %v", report.Errors)
+			}
+			_, statErr := os.Stat(orphan)
+			if removed := os.IsNotExist(statErr); removed != (c.action != gcReport) {
+				t.Fatalf("orphan removed = %v", removed)
+			}
+			if moved := report.OrphanBlobs[0].MovedTo; c.action == gcQuarantine && blobContent(moved) != "orphan" {
+				t.Fatalf("orphan not quarantined at %q", moved)
+			}
+
+			if report.DanglingRecords[0].Processed != c.processed {
+				t.Fatalf("dangling record processed = %v, errors %v", report.DanglingRecords[0].Processed, report.Errors)
+			}
+			if _, ok := fileMap["alice/gone.txt"]; ok == c.processed {
+				t.Fatalf("dangling record in memory = %v", ok)
+			}
+			if len(c.drop) > 0 && fake.count(c.drop) != 1 {
+				t.Fatalf("statements = %v", fake.stmts)
+			}
+			if len(c.failOn) > 0 && len(report.Errors) != 1 {
+				t.Fatalf("errors = %v", report.Errors)
+			}
+			if c.processed && (alice.GetFilenum() != 1 || alice.GetUseddisk() != 4) {
+				t.Fatalf("usage = %v files, %v bytes", alice.GetFilenum(), alice.GetUseddisk())
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"file"
+	"flag"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 版本号,构建时通过-ldflags "-X main.version=..."设置
+var version = "dev"
+
+// 进程启动时间
+var startTime = time.Now()
+
+// 存储目录所在磁盘的最小剩余空间,低于该值时未就绪
+var minFreeDisk int64
+
+// 就绪检查中数据库ping的超时时间
+const readyTimeout = 2 * time.Second
+
+// 进程存活检查
+//
+// 返回:Json{"status", "uptime"}
+func HealthzHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"uptime": time.Since(startTime).Round(time.Second).String(),
+		})
+	}
+}
+
+// 就绪检查,检查数据库连接、存储目录可写以及剩余磁盘空间
+//
+// 返回:Json{"status", "checks"},未就绪时HTTP状态码为503,checks中为各项检查的错误
+func ReadyzHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		checks := gin.H{
+			"db":      checkDB(ctx.Request.Context()),
+			"storage": checkWritable(file.StorageRoot),
+			"disk":    checkFreeDisk(file.StorageRoot),
+		}
+		status, code := "success", http.StatusOK
+		for _, v := range checks {
+			if v != "ok" {
+				status, code = "fail", http.StatusServiceUnavailable
+			}
+		}
+		ctx.JSON(code, gin.H{
+			"status": status,
+			"checks": checks,
+		})
+	}
+}
+
+func checkDB(ctx context.Context) string {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err.Error()
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	if err = sqlDB.PingContext(ctx); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// 在目录中创建并删除临时文件,检查目录可写
+func checkWritable(dir string) string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err.Error()
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err.Error()
+	}
+	f.Close()
+	if err = os.Remove(f.Name()); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+func checkFreeDisk(dir string) string {
+	free, err := freeDisk(dir)
+	if err != nil {
+		return err.Error()
+	}
+	if free >= 0 && free < minFreeDisk {
+		return "free disk space below threshold"
+	}
+	return "ok"
+}
+
+// 运行信息中可以返回的启动参数,不包含密钥文件、链路追踪收集器等路径与地址
+var debugConfigFlags = []string{
+	"reconcile-interval", "gc-interval", "gc-action", "scrub-interval", "compress", "quota-by",
+	"log-level", "log-format", "trace-exporter", "trace-sample", "min-free-mb",
+}
+
+// 管理员查看运行信息
+//
+// 返回:Json{"status", "version", "go_version", "revision", "uptime", "config", "cache", "goroutines"},
+// config中只包含debugConfigFlags中的参数与是否启用加密
+func ManagerDebugInfoHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		revision := ""
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					revision = s.Value
+				}
+			}
+		}
+
+		// /manager接口没有身份验证,只返回不涉及路径、地址与凭据的参数
+		config := gin.H{}
+		for _, name := range debugConfigFlags {
+			if f := flag.Lookup(name); f != nil {
+				config[name] = f.Value.String()
+			}
+		}
+		config["encryption"] = keys != nil
+		if keys != nil {
+			config["active_key"] = keys.Active()
+		}
+
+		userLock.Lock()
+		users := len(userMap)
+		userLock.Unlock()
+		fileLock.Lock()
+		cache := gin.H{
+			"users":       users,
+			"files":       len(fileMap),
+			"owner_lists": len(fileOwnerMap),
+		}
+		fileLock.Unlock()
+		cache["text_index"] = textIndexer.Index().Len()
+		cache["thumbnail_queue"] = len(thumbQueue)
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":     "success",
+			"version":    version,
+			"go_version": runtime.Version(),
+			"revision":   revision,
+			"uptime":     time.Since(startTime).Round(time.Second).String(),
+			"config":     config,
+			"cache":      cache,
+			"goroutines": runtime.NumGoroutine(),
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"file"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 指定主密钥的环境变量,值为base64或十六进制编码的32字节密钥
+const masterKeyEnv = "NETDISK_MASTER_KEY"
+
+type RotateKeyMsg struct {
+	Generate bool `json:"generate"`
+}
+
+// 当前使用的主密钥环
+var keys *file.Keyring
+
+// 根据密钥文件或环境变量设置主密钥,两者都未配置时新文件不加密
+func setupKeyring(keyFile string) error {
+	var err error
+	if len(keyFile) > 0 {
+		keys, err = file.LoadKeyring(keyFile)
+	} else if v := os.Getenv(masterKeyEnv); len(v) > 0 {
+		keys, err = file.NewKeyring("env", v)
+	}
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		slog.Warn("master key not configured, new files will be stored unencrypted")
+	}
+	file.UseKeyring(keys)
+	return nil
+}
+
+// 使用当前主密钥重新包装所有文件的数据密钥
+//
+// 只更新数据库与内存中的包装密钥,不重新加密文件内容
+func rewrapAll() (int, []string) {
+	errs := make([]string, 0)
+	fileLock.Lock()
+	files := make([]file.File, 0)
+	for _, f := range fileMap {
+		if len(f.KeyID) > 0 && f.KeyID != keys.Active() {
+			files = append(files, *f)
+		}
+	}
+	fileLock.Unlock()
+
+	ctl := &file.FileController{}
+	ctl.SetSrv(file.FileServiceImpl{})
+	count := 0
+	for _, f := range files {
+		id, wrapped, err := ctl.Rewrap(f, db)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", f.GetPath(), err))
+			continue
+		}
+		count++
+
+		fileLock.Lock()
+		if mf := fileMap[f.GetPath()]; mf != nil && mf.ID == f.ID {
+			mf.KeyID, mf.WrappedKey = id, wrapped
+		}
+		fileLock.Unlock()
+	}
+	return count, errs
+}
+
+// 管理员轮换主密钥
+//
+// 输入:Json{"generate"},generate为true时先生成新的主密钥并追加到密钥文件;
+// 否则只将仍使用旧主密钥的文件重新包装到当前主密钥
+//
+// 输出:Json{"status", "active", "rewrapped", "errors"}
+func ManagerRotateKeyHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg RotateKeyMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		if keys == nil {
+			fail(ctx, file.ErrNoMasterKey)
+			return
+		}
+		if msg.Generate {
+			if _, err := keys.Generate(); err != nil {
+				fail(ctx, err)
+				return
+			}
+		}
+		count, errs := rewrapAll()
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":    "success",
+			"active":    keys.Active(),
+			"rewrapped": count,
+			"errors":    errs,
+		})
+	}
+}
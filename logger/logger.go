@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// 超过该时间的数据库操作记录为慢查询
+const SlowQuery = 200 * time.Millisecond
+
+// 当前的日志级别,可以在运行时修改
+var level = new(slog.LevelVar)
+
+// 设置全局日志,format为json或text,level为debug、info、warn或error
+//
+// 同时接管标准库log包的输出
+func Setup(w io.Writer, format, lvl string) error {
+	if err := level.UnmarshalText([]byte(lvl)); err != nil {
+		return fmt.Errorf("invalid log level %q", lvl)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// 记录错误后退出
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type ctxKey struct{}
+
+// 返回携带日志对象的context
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// 获取context携带的日志对象,没有时返回全局日志
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// 获取数据库会话所属请求的日志对象,服务层通过传入的*gorm.DB获取请求id等字段
+func FromDB(db *gorm.DB) *slog.Logger {
+	if db == nil || db.Statement == nil {
+		return slog.Default()
+	}
+	return FromContext(db.Statement.Context)
+}
+
+// 将GORM的日志输出到slog,包含请求的字段
+//
+// 所有语句记录为debug级别,慢查询为warn级别,错误为error级别,记录不存在不视为错误
+//
+// 只记录带占位符的语句模板与影响行数,不记录绑定的参数,避免密码等内容写入日志
+type Gorm struct{}
+
+func (g Gorm) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return g
+}
+
+func (g Gorm) Info(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+}
+
+func (g Gorm) Warn(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+}
+
+func (g Gorm) Error(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+}
+
+// 丢弃绑定的参数,GORM生成日志中的语句时保留占位符
+func (g Gorm) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+func (g Gorm) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l := FromContext(ctx)
+	elapsed := time.Since(begin)
+	lvl := slog.LevelDebug
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		lvl = slog.LevelError
+	case elapsed > SlowQuery:
+		lvl = slog.LevelWarn
+	}
+	if !l.Enabled(ctx, lvl) {
+		return
+	}
+	sql, rows := fc()
+	args := []any{
+		"component", "gorm",
+		"sql", strings.TrimSpace(sql),
+		"rows", rows,
+		"elapsed_ms", float64(elapsed.Microseconds()) / 1000,
+	}
+	if err != nil {
+		args = append(args, "err", err)
+	}
+	l.Log(ctx, lvl, "query", args...)
+}
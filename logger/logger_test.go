@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestGormTraceRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	old := slog.Default()
+	if err := Setup(&buf, "json", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		slog.SetDefault(old)
+		level.Set(slog.LevelInfo)
+	})
+
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "test@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: Gorm{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type user struct {
+		Name     string
+		Password string
+	}
+	db.Where("name = ? AND password = ?", "alice", "hunter2").Find(&[]user{})
+	db.Create(&user{Name: "bob", Password: "s3cret"})
+
+	out := buf.String()
+	if !strings.Contains(out, "password = ?") {
+		t.Fatalf("sql template not logged: %s", out)
+	}
+	for _, v := range []string{"alice", "hunter2", "bob", "s3cret"} {
+		if strings.Contains(out, v) {
+			t.Fatalf("bound value %q logged: %s", v, out)
+		}
+	}
+}
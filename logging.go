@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"logger"
+	"time"
+	"tracing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 传递请求id的请求头,客户端未提供时由服务端生成
+const requestIDHeader = "X-Request-ID"
+
+// 记录fail返回的失败原因,写入访问日志
+const failReasonKey = "fail_reason"
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 为每个请求设置带请求id的日志对象,并在请求结束后记录访问日志
+func requestLogger() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		id := ctx.GetHeader(requestIDHeader)
+		if len(id) == 0 || len(id) > 64 {
+			id = newRequestID()
+		}
+		ctx.Header(requestIDHeader, id)
+		l := slog.Default().With("request_id", id)
+		if traceID := tracing.TraceID(ctx.Request.Context()); len(traceID) > 0 {
+			l = l.With("trace_id", traceID)
+		}
+		ctx.Request = ctx.Request.WithContext(logger.WithContext(ctx.Request.Context(), l))
+
+		ctx.Next()
+
+		lvl := slog.LevelInfo
+		if ctx.Writer.Status() >= 500 {
+			lvl = slog.LevelError
+		} else if _, failed := ctx.Get(failReasonKey); failed {
+			lvl = slog.LevelWarn
+		}
+		args := []any{
+			"method", ctx.Request.Method,
+			"route", ctx.FullPath(),
+			"url", ctx.Request.URL.Path,
+			"status", ctx.Writer.Status(),
+			"bytes", ctx.Writer.Size(),
+			"client_ip", ctx.ClientIP(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if reason, ok := ctx.Get(failReasonKey); ok {
+			args = append(args, "reason", reason)
+		}
+		reqLog(ctx).Log(ctx.Request.Context(), lvl, "request", args...)
+	}
+}
+
+// 获取请求的日志对象
+func reqLog(ctx *gin.Context) *slog.Logger {
+	return logger.FromContext(ctx.Request.Context())
+}
+
+// 为请求的日志添加user_id、path等字段,之后的日志与数据库日志都会包含这些字段
+func annotate(ctx *gin.Context, args ...any) {
+	l := reqLog(ctx).With(args...)
+	ctx.Request = ctx.Request.WithContext(logger.WithContext(ctx.Request.Context(), l))
+}
+
+// 请求使用的数据库会话,服务层与数据库日志通过它获取请求的字段
+func reqDB(ctx *gin.Context) *gorm.DB {
+	return db.WithContext(ctx.Request.Context())
+}
@@ -1,25 +1,44 @@
 package main
 
 import (
+	"audit"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"file"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"log/slog"
+	"logger"
 	"net/http"
+	"os"
+	"os/signal"
+	"search"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
+	"tracing"
 	"user"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 type RawUser struct {
-	UserID   string `json:"user_id"`
-	Password string `json:"password"`
-	Disk     int64  `json:"disk"`
+	UserID   string `json:"user_id" binding:"required,max=64"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RegisterMsg struct {
+	UserID   string `json:"user_id" binding:"required,userid"`
+	Password string `json:"password" binding:"required,password"`
+	Disk     int64  `json:"disk" binding:"gte=0"`
 }
 
 type SimpleFile struct {
@@ -28,68 +47,337 @@ type SimpleFile struct {
 	Target   []string `json:"target"`
 }
 
+type FileInfo struct {
+	FilePath  string    `json:"file_path"`
+	Name      string    `json:"name"`
+	Uploader  string    `json:"uploader"`
+	Target    []string  `json:"target"`
+	Tags      []string  `json:"tags"`
+	Size      int64     `json:"size"`
+	Mime      string    `json:"mime"`
+	Ext       string    `json:"ext"`
+	Category  string    `json:"category"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type FriendMsg struct {
-	Me     string `json:"me"`
-	Friend string `json:"friend"`
+	Me     string `json:"me" binding:"required,max=64"`
+	Friend string `json:"friend" binding:"required,max=64"`
 }
 
 type DeleteMsg struct {
-	UserID    string `json:"user_id"`
-	ManagerID string `json:"manager_id"`
+	// 逗号分隔的用户
+	UserID    string `json:"user_id" binding:"required,max=4096"`
+	ManagerID string `json:"manager_id" binding:"max=64"`
 }
 
 type TargetMsg struct {
-	UserID string `json:"user_id"`
-	Target string `json:"target"`
-	Path   string `json:"path"`
+	UserID string `json:"user_id" binding:"required,max=64"`
+	// 逗号分隔的用户
+	Target string `json:"target" binding:"max=4096"`
+	Path   string `json:"path" binding:"required,filepath"`
+}
+
+type CopyMsg struct {
+	UserID string `json:"user_id" binding:"required,max=64"`
+	Path   string `json:"path" binding:"required,filepath"`
+	Dest   string `json:"dest" binding:"omitempty,filename"`
+}
+
+type ArchiveMsg struct {
+	UserID string   `json:"user_id" binding:"required,max=64"`
+	Paths  []string `json:"paths" binding:"max=1000,dive,filepath"`
+	Folder string   `json:"folder" binding:"max=1024"`
+	Format string   `json:"format" binding:"omitempty,oneof=zip tar.gz"`
+}
+
+type TagMsg struct {
+	UserID string `json:"user_id" binding:"required,max=64"`
+	Path   string `json:"path" binding:"required,filepath"`
+	Tags   string `json:"tags" binding:"max=1024"`
 }
 
 type QueryMsg struct {
-	UserID        string `json:"user_id"`
-	LowerSpace    string `json:"lower_space"`
-	HigherSpace   string `json:"higher_space"`
-	LowerFileNum  string `json:"lower_file_num"`
-	HigherFileNum string `json:"higher_file_num"`
+	UserID        string     `json:"user_id" binding:"max=64"`
+	MinUsed       *int64     `json:"min_used" binding:"omitempty,gte=0"`
+	MaxUsed       *int64     `json:"max_used" binding:"omitempty,gte=0"`
+	MinDisk       *int64     `json:"min_disk" binding:"omitempty,gte=0"`
+	MaxDisk       *int64     `json:"max_disk" binding:"omitempty,gte=0"`
+	MinFileNum    *int       `json:"min_file_num" binding:"omitempty,gte=0"`
+	MaxFileNum    *int       `json:"max_file_num" binding:"omitempty,gte=0"`
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
+	LoginAfter    *time.Time `json:"login_after"`
+	LoginBefore   *time.Time `json:"login_before"`
+	Sort          string     `json:"sort"`
+	Order         string     `json:"order" binding:"omitempty,oneof=asc desc"`
+	Offset        int        `json:"offset" binding:"gte=0"`
+	Limit         int        `json:"limit"`
+	Format        string     `json:"format" binding:"omitempty,oneof=json csv"`
+}
+
+// 管理员查询结果中的用户信息,不包含密码
+type UserView struct {
+	UserID    string     `json:"user_id"`
+	Friends   []string   `json:"friends"`
+	FileNum   int        `json:"file_num"`
+	DiskUsed  int64      `json:"disk_used"`
+	Disk      int64      `json:"disk"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastLogin *time.Time `json:"last_login"`
 }
 
+// 数据库连接
+const dsn = "gorm:gorm@tcp(127.0.0.1:9910)/gorm?parseTime=true"
+
 // 数据库全局对象
 var db *gorm.DB
 
 // 用户列表
 var userMap map[string]*user.User
-var userLock sync.Mutex
+var userLock = newTimedMutex("user")
 
 // 用户可下载的文件列表
 var fileOwnerMap map[string][]*file.File
-var fileLock sync.Mutex
+var fileLock = newTimedMutex("file")
 
 // 文件列表
 var fileMap map[string]*file.File
 
+// 文件内容全文索引
+var textIndexer *search.Indexer
+
 // 返回失败HTTP响应
 //
-// Json{"status", "reason"}
-func fail(ctx *gin.Context, reason string) {
-	ctx.JSON(http.StatusOK, gin.H{
+// 旧接口:HTTP状态码200,Json{"status", "reason", "fields", "trace_id"}
+//
+// 新接口(/v2):错误对应的HTTP状态码,Json{"status", "code", "message", "detail", "fields", "trace_id"},
+// code为稳定的错误码,message根据lang参数或Accept-Language请求头为中文或英文;
+// 请求体校验失败时fields为每个字段的错误[{"field", "rule", "message"}]
+func fail(ctx *gin.Context, err error) {
+	ctx.JSON(failResponse(ctx, err))
+}
+
+// 失败响应的HTTP状态码与响应体,批量接口在响应体中附加各项结果
+func failResponse(ctx *gin.Context, err error) (int, gin.H) {
+	reason := err.Error()
+	ctx.Set(failReasonKey, reason)
+	trace.SpanFromContext(ctx.Request.Context()).SetStatus(codes.Error, reason)
+	res := gin.H{
 		"status": "fail",
-		"reason": reason,
-	})
+	}
+	var fields validationError
+	if errors.As(err, &fields) {
+		res["fields"] = fields
+	}
+	s := lookupError(err)
+	if s.code == internalError.code {
+		reqLog(ctx).Error("request failed", "err", err)
+	}
+	status := http.StatusOK
+	if isV2(ctx) {
+		status = s.status
+		res["code"], res["message"] = s.code, localize(ctx, s)
+		if detail := errorDetail(err, s); len(detail) > 0 {
+			res["detail"] = detail
+		}
+	} else {
+		res["reason"] = reason
+	}
+	// 附带trace id,便于根据失败的响应查找链路
+	if id := tracing.TraceID(ctx.Request.Context()); len(id) > 0 {
+		res["trace_id"] = id
+	}
+	return status, res
+}
+
+// 读取可选的整数查询参数,参数不存在时返回nil
+func queryInt64(ctx *gin.Context, key string) (*int64, error) {
+	s := ctx.Query(key)
+	if len(s) == 0 {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, invalidParam("invalid %v", key)
+	}
+	return &v, nil
+}
+
+// 读取可选的时间查询参数,支持RFC3339和日期格式
+func queryTime(ctx *gin.Context, key string) (*time.Time, error) {
+	s := ctx.Query(key)
+	if len(s) == 0 {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02", s, time.Local)
+	}
+	if err != nil {
+		return nil, invalidParam("invalid %v", key)
+	}
+	return &t, nil
+}
+
+// 读取分页数量参数
+func queryLimit(ctx *gin.Context, def, max int) (int, error) {
+	s := ctx.Query("limit")
+	if len(s) == 0 {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 || limit > max {
+		return 0, invalidParam("invalid limit")
+	}
+	return limit, nil
+}
+
+// 生成文件列表项,分享目标只向上传者展示
+func fileInfo(f *file.File, uid string) FileInfo {
+	target := make([]string, 0)
+	if uid == f.GetUploader() {
+		target = f.GetTarget()
+	}
+	return FileInfo{
+		FilePath:  f.GetPath(),
+		Name:      f.GetName(),
+		Uploader:  f.GetUploader(),
+		Target:    target,
+		Tags:      f.GetTags(),
+		Size:      f.GetConsume(),
+		Mime:      f.Mime,
+		Ext:       f.Ext,
+		Category:  f.Category,
+		SHA256:    f.SHA256,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+	}
+}
+
+// 从用户可下载的文件列表中移除文件,调用者需持有fileLock
+func removeOwned(uid, path string) {
+	for i, f := range fileOwnerMap[uid] {
+		if f.Path == path {
+			fileOwnerMap[uid] = append(fileOwnerMap[uid][:i], fileOwnerMap[uid][i+1:]...)
+			return
+		}
+	}
 }
 
-func init() {
+// 文件记录删除后,从内存中移除文件并更新上传者的文件数和空间,调用者需持有fileLock
+func forgetFile(f *file.File) {
+	for _, uid := range append(f.GetTarget(), f.GetUploader()) {
+		removeOwned(uid, f.GetPath())
+	}
+	delete(fileMap, f.GetPath())
+	textIndexer.Remove(f.GetPath())
+	if err := file.RemoveThumbnails(f); err != nil {
+		slog.Warn("remove thumbnails failed", "path", f.GetPath(), "err", err)
+	}
+
+	u := userMap[f.GetUploader()]
+	if u == nil {
+		return
+	}
+	u.SetUseddisk(u.GetUseddisk() - f.Charge())
+	u.SetFilenum(u.GetFilenum() - 1)
+	if err := u.SaveUsage(db); err != nil {
+		slog.Error("update user usage failed", "user_id", u.GetId(), "err", err)
+	}
+}
+
+// 文件记录移动或修改分享目标后,用新的状态替换内存中的文件,调用者需持有fileLock
+func replaceFile(f *file.File, cur file.File) {
+	for _, uid := range append(f.GetTarget(), f.GetUploader()) {
+		removeOwned(uid, f.GetPath())
+	}
+	if cur.Path != f.Path {
+		delete(fileMap, f.Path)
+		textIndexer.Remove(f.Path)
+		defer textIndexer.Add(cur.Path, contentOpener(cur))
+	}
+	*f = cur
+	fileMap[f.Path] = f
+	for _, uid := range append(f.GetTarget(), f.GetUploader()) {
+		fileOwnerMap[uid] = append(fileOwnerMap[uid], f)
+	}
+}
+
+// 从逗号分隔的分享目标中筛选出用户的好友
+func friendTargets(u *user.User, target string) []string {
+	friends := u.GetFriends()
+	realTarget := make([]string, 0)
+	for _, t := range strings.Split(target, ",") {
+		if len(t) > 0 && t != u.Id {
+			for _, v := range friends {
+				if t == v {
+					realTarget = append(realTarget, t)
+					break
+				}
+			}
+		}
+	}
+	return realTarget
+}
+
+// 文件记录创建后,将文件加入内存并更新上传者的文件数和空间,调用者需持有fileLock
+func rememberFile(u *user.User, f *file.File) {
+	fileOwnerMap[f.Uploader] = append(fileOwnerMap[f.Uploader], f)
+	fileMap[f.Path] = f
+	textIndexer.Add(f.Path, contentOpener(*f))
+	queueThumbnails(f)
+	if err := file.RecordActivity(file.ActivityUpload, f.Uploader, f.Path, f.GetConsume(), db); err != nil {
+		slog.Warn("record upload failed", "path", f.Path, "err", err)
+	}
+	u.SetUseddisk(u.GetUseddisk() + f.Charge())
+	u.SetFilenum(u.GetFilenum() + 1)
+	if err := u.SaveUsage(db); err != nil {
+		slog.Error("update user usage failed", "user_id", u.GetId(), "err", err)
+	}
+}
+
+// 生成不包含密码的用户信息
+func userView(u *user.User) UserView {
+	return UserView{
+		UserID:    u.GetId(),
+		Friends:   u.GetFriends(),
+		FileNum:   u.GetFilenum(),
+		DiskUsed:  u.GetUseddisk(),
+		Disk:      u.GetDisk(),
+		CreatedAt: u.CreatedAt,
+		LastLogin: u.GetLastLogin(),
+	}
+}
+
+// 连接数据库,迁移表结构并加载所有用户与文件
+func setupDB() {
 	var err error
 	var userlist []user.User
 	var filelist []file.File
-	db, err = gorm.Open(mysql.Open("gorm:gorm@tcp(127.0.0.1:9910)/gorm?parseTime=true"))
+	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Gorm{}})
 	if err != nil {
-		log.Fatalf("%v when init db", err.Error())
+		logger.Fatal("init db failed", "err", err)
+	}
+	if err = instrumentDB(db); err != nil {
+		logger.Fatal("register db metrics failed", "err", err)
+	}
+	if err = db.Use(tracing.Gorm{}); err != nil {
+		logger.Fatal("register db tracing failed", "err", err)
+	}
+	db.AutoMigrate(&user.User{})
+	if err = file.Migrate(db); err != nil {
+		logger.Fatal("migrate file table failed", "err", err)
+	}
+	if err = audit.Migrate(db); err != nil {
+		logger.Fatal("migrate audit log failed", "err", err)
 	}
-	db.AutoMigrate(&user.User{}, &file.File{})
 
 	// 获取数据库内用户
-	db.Find(&userlist)
-	if db.Error != nil {
-		log.Fatalf("%v when init user slice", db.Error)
+	if err = db.Find(&userlist).Error; err != nil {
+		logger.Fatal("init user slice failed", "err", err)
 	}
 	userMap = make(map[string]*user.User, len(userlist))
 	for i := range userlist {
@@ -98,9 +386,8 @@ func init() {
 
 	// 获取数据库内文件信息
 	fileOwnerMap = make(map[string][]*file.File, len(userlist))
-	db.Find(&filelist)
-	if db.Error != nil {
-		log.Fatalf("%v when init file slice", db.Error)
+	if err = db.Find(&filelist).Error; err != nil {
+		logger.Fatal("init file slice failed", "err", err)
 	}
 	fileMap = make(map[string]*file.File, len(filelist))
 	for i := range filelist {
@@ -113,13 +400,146 @@ func init() {
 	}
 }
 
+// 返回读取文件内容的函数,供后台任务在不持有fileLock时读取
+func contentOpener(f file.File) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return file.OpenContent(&f)
+	}
+}
+
+// 在后台建立全文索引
+//
+// 复制文件列表后释放fileLock,再逐个提交,索引队列满时不阻塞其他请求
+func startIndexer() {
+	textIndexer = search.NewIndexer()
+	fileLock.Lock()
+	files := make([]file.File, 0, len(fileMap))
+	for _, f := range fileMap {
+		files = append(files, *f)
+	}
+	fileLock.Unlock()
+	go func() {
+		for _, f := range files {
+			textIndexer.AddWait(f.GetPath(), contentOpener(f))
+		}
+	}()
+}
+
 func main() {
-	r := gin.Default()
+	reconcileOnly := flag.Bool("reconcile", false, "核对用户用量并输出差异后退出")
+	reconcileFix := flag.Bool("fix", false, "与-reconcile一起使用,修复发现的差异")
+	reconcileEvery := flag.Duration("reconcile-interval", 0, "定期核对用户用量的间隔,0表示不启用")
+	gcEvery := flag.Duration("gc-interval", 0, "定期回收孤儿文件与悬空记录的间隔,0表示不启用")
+	gcAction := flag.String("gc-action", gcQuarantine, "定期回收的处理方式:report/quarantine/delete")
+	scrubEvery := flag.Duration("scrub-interval", 0, "定期校验文件内容的间隔,0表示不启用")
+	compress := flag.Bool("compress", false, "压缩存储可压缩的文件")
+	quotaBy := flag.String("quota-by", "logical", "配额计算方式:logical按原始大小,physical按压缩、加密后的实际占用空间")
+	keyFile := flag.String("key-file", "", "主密钥文件,文件不存在时自动生成;也可通过环境变量"+masterKeyEnv+"指定主密钥")
+	logLevel := flag.String("log-level", "info", "日志级别:debug/info/warn/error,debug级别会记录所有数据库语句(不含参数)")
+	logFormat := flag.String("log-format", "json", "日志格式:json/text")
+	traceExporter := flag.String("trace-exporter", tracing.ExporterNone, "链路追踪导出方式:none/otlp/stdout")
+	traceEndpoint := flag.String("trace-endpoint", "localhost:4318", "OTLP/HTTP收集器地址")
+	traceSample := flag.Float64("trace-sample", 1, "链路追踪采样比例,上游已采样的请求总是采样")
+	minFreeMB := flag.Int64("min-free-mb", 1024, "存储目录所在磁盘的最小剩余空间(MB),低于该值时/readyz返回未就绪")
+	flag.Parse()
+	minFreeDisk = *minFreeMB << 20
+
+	if err := logger.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logger.Fatal("setup logger failed", "err", err)
+	}
+	setupDB()
+	shutdownTracing, err := tracing.Setup(*traceExporter, *traceEndpoint, *traceSample, version)
+	if err != nil {
+		logger.Fatal("setup tracing failed", "err", err)
+	}
+	// 正常退出与收到退出信号时发送缓冲中的span,logger.Fatal直接退出时不会执行
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("shutdown tracing failed", "err", err)
+		}
+	}()
+	if err := setupKeyring(*keyFile); err != nil {
+		logger.Fatal("load master key failed", "err", err)
+	}
+	if *quotaBy != "logical" && *quotaBy != "physical" {
+		logger.Fatal("invalid quota-by", "quota_by", *quotaBy)
+	}
+	file.UseCompression(*compress)
+	file.UsePhysicalQuota(*quotaBy == "physical")
+	startIndexer()
+	startThumbnailer()
+
+	if *reconcileOnly {
+		res, err := reconcile(nil, *reconcileFix)
+		if err != nil {
+			logger.Fatal("reconcile usage failed", "err", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+		return
+	}
+	if *reconcileEvery > 0 {
+		go reconcileLoop(*reconcileEvery)
+	}
+	if *gcEvery > 0 {
+		go gcLoop(*gcEvery, *gcAction)
+	}
+	if *scrubEvery > 0 {
+		go scrubLoop(*scrubEvery)
+	}
+
+	serve(&http.Server{Addr: "127.0.0.1:8080", Handler: newRouter()})
+}
+
+// 创建包含所有中间件与接口的路由
+func newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(tracing.Gin(), requestLogger(), gin.Recovery(), requestMetrics(), auditTrail())
+	r.GET("metrics", MetricsHandler())
+	r.GET("healthz", HealthzHandler())
+	r.GET("readyz", ReadyzHandler())
+	r.GET("openapi.json", OpenAPIHandler())
+	registerRoutes(r)
+	// 新版接口,失败时返回对应的HTTP状态码、错误码与本地化的错误信息
+	registerRoutes(r.Group("v2", apiV2()))
+	return r
+}
+
+// 收到退出信号后等待处理中的请求完成的最长时间
+const shutdownTimeout = 10 * time.Second
+
+// 启动服务,收到SIGINT或SIGTERM时停止接收新请求,等待处理中的请求完成后返回
+func serve(srv *http.Server) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		slog.Error("server stopped", "err", err)
+	case <-ctx.Done():
+		slog.Info("shutting down")
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			slog.Warn("shutdown server failed", "err", err)
+		}
+	}
+}
+
+// 注册用户、管理员与文件接口,旧接口与/v2下的新接口共用
+func registerRoutes(r gin.IRouter) {
 	ug := r.Group("user")
 	{
 		ug.POST("register", UserRegisterHandler())
 		ug.POST("login", UserLoginHandler())
 		ug.GET("list", UserListHandler())
+		ug.GET("files", UserFileListHandler())
 		ug.GET("files/:user_id", UserFilesHandler())
 		ug.GET("friends/:user_id", UserFriendsListHandler())
 		ug.POST("update/friend", UserAddFriendHandler())
@@ -128,16 +548,34 @@ func main() {
 	{
 		mg.POST("delete", ManagerDeleteHandler())
 		mg.POST("query", ManagerQueryHandler())
+		mg.GET("stats", ManagerStatsHandler())
+		mg.POST("reconcile", ManagerReconcileHandler())
+		mg.POST("gc", ManagerGCHandler())
+		mg.POST("scrub", ManagerScrubHandler())
+		mg.GET("corrupt", ManagerCorruptHandler())
+		mg.POST("keys/rotate", ManagerRotateKeyHandler())
+		mg.GET("audit", ManagerAuditHandler())
+		mg.GET("audit/verify", ManagerAuditVerifyHandler())
+		mg.GET("debug/info", ManagerDebugInfoHandler())
 	}
 	fg := r.Group("file")
 	{
-		fg.POST("upload/:user/:path", FileUploadHandler())
+		fg.POST("upload/:user/:path", transferMetrics(transferUpload), FileUploadHandler())
+		fg.POST("upload-archive/:user", transferMetrics(transferUpload), FileUploadArchiveHandler())
+		fg.POST("batch", FileBatchHandler())
+		fg.POST("copy", FileCopyHandler())
+		fg.POST("move", FileMoveHandler())
 		fg.POST("target", FileTargetHandler())
 		fg.GET("owner", FileOwnerHandler())
-		fg.POST("download", FileDownloadHandler())
+		fg.GET("search", FileSearchHandler())
+		fg.GET("fulltext", FileFullTextHandler())
+		fg.POST("tags", FileTagsHandler())
+		fg.POST("download", transferMetrics(transferDownload), FileDownloadHandler())
+		fg.GET("thumbnail", FileThumbnailHandler())
+		fg.GET("preview", FilePreviewHandler())
+		fg.POST("archive", transferMetrics(transferDownload), FileArchiveHandler())
 		fg.POST("delete", FileDeleteHandler())
 	}
-	r.Run("127.0.0.1:8080")
 }
 
 // 用户注册
@@ -151,21 +589,26 @@ func UserRegisterHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userLock.Lock()
 		defer userLock.Unlock()
-		var u RawUser
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &u)
+		var u RegisterMsg
+		if err := bindJSON(ctx, &u); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", u.UserID)
+		auditEvent(ctx, "user.register", u.UserID, u.UserID, "")
 
 		// 判断是否存在同名用户，不允许重复注册
 		if _, ok := userMap[u.UserID]; ok {
-			fail(ctx, "user already exist")
+			fail(ctx, user.ErrUserExisted)
 			return
 		}
 
 		// 生成用户数据
 		current_user := user.User{Id: u.UserID, Password: u.Password, Disk: u.Disk}
-		db.Create(&current_user)
-		if db.Error != nil {
-			log.Printf("%v when create user", db.Error)
+		if err := reqDB(ctx).Create(&current_user).Error; err != nil {
+			reqLog(ctx).Error("create user failed", "err", err)
+			fail(ctx, err)
+			return
 		}
 
 		// 加入数据结构中
@@ -187,16 +630,24 @@ func UserRegisterHandler() gin.HandlerFunc {
 func UserLoginHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var msg RawUser
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &msg)
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID)
+		auditEvent(ctx, "user.login", msg.UserID, msg.UserID, "")
 
 		userLock.Lock()
 		defer userLock.Unlock()
 		u := userMap[msg.UserID]
 		if u == nil || u.GetPassword() != msg.Password {
-			fail(ctx, "user not exist")
+			// 旧接口不区分用户不存在与密码错误
+			fail(ctx, legacy(user.ErrInvalidCredentials, "user not exist"))
 			return
 		}
+		if err := u.SetLastLogin(time.Now(), reqDB(ctx)); err != nil {
+			reqLog(ctx).Warn("update last login failed", "err", err)
+		}
 		ctx.JSON(http.StatusOK, gin.H{
 			"status":  "success",
 			"user_id": msg.UserID,
@@ -223,9 +674,10 @@ func UserFilesHandler() gin.HandlerFunc {
 		fileLock.Lock()
 		defer fileLock.Unlock()
 		uid := ctx.Param("user_id")
+		annotate(ctx, "user_id", uid)
 		u := userMap[uid]
 		if u == nil {
-			fail(ctx, "user not exist")
+			fail(ctx, user.ErrUserNotExist)
 			return
 		}
 
@@ -249,6 +701,69 @@ func UserFilesHandler() gin.HandlerFunc {
 	}
 }
 
+// 分页获取用户可以下载的文件列表
+//
+// 参数:user_id, scope(all/owned/shared), uploader, ext, category, min_size, max_size,
+// sort(name/size/created/updated), order(asc/desc), cursor, limit
+//
+// 返回:Json{"status", "files", "next_cursor", "file_num", "space_used"}
+func UserFileListHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		fileLock.Lock()
+		defer fileLock.Unlock()
+		uid := ctx.Query("user_id")
+		annotate(ctx, "user_id", uid)
+		u := userMap[uid]
+		if u == nil {
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+
+		opt := file.ListOption{
+			Scope:    ctx.Query("scope"),
+			Uploader: ctx.Query("uploader"),
+			Ext:      ctx.Query("ext"),
+			Category: ctx.Query("category"),
+			Sort:     ctx.Query("sort"),
+			Desc:     ctx.Query("order") == "desc",
+			Cursor:   ctx.Query("cursor"),
+		}
+		var err error
+		if opt.MinSize, err = queryInt64(ctx, "min_size"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if opt.MaxSize, err = queryInt64(ctx, "max_size"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if opt.Limit, err = queryLimit(ctx, 50, 500); err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		files, next, err := ctl.ListFiles(ctx.Request.Context(), fileOwnerMap[uid], uid, opt)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		res := make([]FileInfo, 0, len(files))
+		for _, f := range files {
+			res = append(res, fileInfo(f, uid))
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":      "success",
+			"files":       res,
+			"next_cursor": next,
+			"file_num":    u.GetFilenum(),
+			"space_used":  fmt.Sprintf("%v/%v", u.GetUseddisk(), u.GetDisk()),
+		})
+	}
+}
+
 // 用户添加好友
 //
 // 输入:Json{"me", "friend"}
@@ -259,24 +774,28 @@ func UserAddFriendHandler() gin.HandlerFunc {
 		fileLock.Lock()
 		defer fileLock.Unlock()
 		var m FriendMsg
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &m)
+		if err := bindJSON(ctx, &m); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", m.Me)
+		auditEvent(ctx, "user.friend", m.Me, m.Friend, "")
 
 		if _, ok := userMap[m.Me]; !ok {
-			fail(ctx, "me not exist")
+			fail(ctx, legacy(user.ErrUserNotExist, "me not exist"))
 			return
 		}
 
 		if _, ok := userMap[m.Friend]; !ok {
-			fail(ctx, "target not exist")
+			fail(ctx, legacy(user.ErrUserNotExist, "target not exist"))
 			return
 		}
 
 		ctl := &user.UserController{}
 		ctl.SetSrv(user.UserServiceImpl{})
-		err := ctl.UpdateFriends(userMap[m.Me], m.Friend, db)
+		err := ctl.UpdateFriends(userMap[m.Me], m.Friend, reqDB(ctx))
 		if err != nil {
-			fail(ctx, err.Error())
+			fail(ctx, err)
 			return
 		}
 		ctx.JSON(http.StatusOK, gin.H{
@@ -296,20 +815,27 @@ func ManagerDeleteHandler() gin.HandlerFunc {
 		defer userLock.Unlock()
 		var msg DeleteMsg
 		// 读取要删除的用户名
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &msg)
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if len(msg.ManagerID) == 0 {
+			msg.ManagerID = ctx.GetHeader(managerIDHeader)
+		}
 
 		for _, u := range strings.Split(msg.UserID, ",") {
+			e := auditEvent(ctx, "manager.delete", msg.ManagerID, u, "")
 			// 删除用户
 			if _, ok := userMap[u]; ok {
-				db.Delete(userMap[u])
-			}
-			if db.Error != nil {
-				fail(ctx, db.Error.Error())
-				return
+				if err := reqDB(ctx).Delete(userMap[u]).Error; err != nil {
+					fail(ctx, err)
+					return
+				}
 			}
 			// 将用户从内存用户表中删除
 			delete(userMap, u)
+			// 之前已删除的用户不受后面失败的影响
+			e.Outcome = audit.OutcomeSuccess
 		}
 		ctx.JSON(http.StatusOK, gin.H{
 			"status": "success",
@@ -319,28 +845,157 @@ func ManagerDeleteHandler() gin.HandlerFunc {
 
 // 管理员查找用户
 //
-// 输入:Json{"user_id", "lower_space", "higher_space", "lower_file_num", "higher_file_num"}
+// 输入:Json{"user_id", "min_used", "max_used", "min_disk", "max_disk",
+// "min_file_num", "max_file_num", "created_after", "created_before",
+// "login_after", "login_before", "sort", "order", "offset", "limit", "format"}
 //
-// 输出:Json{"users":[]User}
+// user_id按子串匹配,其余条件均可省略;format为csv时导出CSV文件
+//
+// 输出:Json{"status", "total", "users":[]UserView}
 func ManagerQueryHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var msg QueryMsg
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &msg)
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		// 导出CSV时允许更大的单页数量
+		maxLimit := 500
+		if msg.Format == "csv" {
+			maxLimit = 100000
+		}
+		if msg.Limit == 0 {
+			msg.Limit = 50
+		}
+		if msg.Limit < 0 || msg.Limit > maxLimit {
+			fail(ctx, invalidParam("invalid limit"))
+			return
+		}
+
+		ctl := &user.UserController{}
+		ctl.SetSrv(user.UserServiceImpl{})
+		users, total, err := ctl.Query(user.UserQuery{
+			IDContains:    msg.UserID,
+			MinUsed:       msg.MinUsed,
+			MaxUsed:       msg.MaxUsed,
+			MinDisk:       msg.MinDisk,
+			MaxDisk:       msg.MaxDisk,
+			MinFiles:      msg.MinFileNum,
+			MaxFiles:      msg.MaxFileNum,
+			CreatedAfter:  msg.CreatedAfter,
+			CreatedBefore: msg.CreatedBefore,
+			LoginAfter:    msg.LoginAfter,
+			LoginBefore:   msg.LoginBefore,
+			Sort:          msg.Sort,
+			Desc:          msg.Order == "desc",
+			Offset:        msg.Offset,
+			Limit:         msg.Limit,
+		}, reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		views := make([]UserView, 0, len(users))
+		for i := range users {
+			views = append(views, userView(&users[i]))
+		}
+
+		if msg.Format == "csv" {
+			writeUsersCSV(ctx, views)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"total":  total,
+			"users":  views,
+		})
+	}
+}
 
-		var users []user.User
+// 管理员查看存储使用统计
+//
+// 参数:days(活动统计的天数,默认30), top(使用空间最多的用户数,默认10)
+//
+// 输出:Json{"status", "total_users", "total_files", "bytes_stored", "bytes_allocated",
+// "bytes_physical", "bytes_used", "top_users", "size_histogram", "type_distribution", "daily_activity",
+// "duplicate_files", "dedup_savings"}
+func ManagerStatsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		days, err := strconv.Atoi(ctx.DefaultQuery("days", "30"))
+		if err != nil || days <= 0 || days > 365 {
+			fail(ctx, invalidParam("invalid days"))
+			return
+		}
+		top, err := strconv.Atoi(ctx.DefaultQuery("top", "10"))
+		if err != nil || top <= 0 || top > 100 {
+			fail(ctx, invalidParam("invalid top"))
+			return
+		}
+
+		uctl := &user.UserController{}
+		uctl.SetSrv(user.UserServiceImpl{})
+		ustats, err := uctl.Stats(top, reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		fctl := &file.FileController{}
+		fctl.SetSrv(file.FileServiceImpl{})
+		fstats, err := fctl.Stats(days, reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
 
-		if len(msg.UserID) > 0 {
-			db.Where("user_id = ?", msg.UserID).Find(&users)
-		} else {
-			db.Where("disk_len > ?", msg.LowerSpace).Where("disk_len < ?", msg.HigherSpace).Where("file_num > ?", msg.LowerFileNum).Where("file_num < ?", msg.HigherFileNum).Find(&users)
+		topUsers := make([]UserView, 0, len(ustats.Top))
+		for i := range ustats.Top {
+			topUsers = append(topUsers, userView(&ustats.Top[i]))
 		}
 		ctx.JSON(http.StatusOK, gin.H{
-			"users": users,
+			"status":            "success",
+			"total_users":       ustats.Users,
+			"total_files":       fstats.Files,
+			"bytes_stored":      fstats.Bytes,
+			"bytes_physical":    fstats.PhysicalBytes,
+			"bytes_allocated":   ustats.Allocated,
+			"bytes_used":        ustats.Used,
+			"top_users":         topUsers,
+			"size_histogram":    fstats.Histogram,
+			"type_distribution": fstats.Types,
+			"daily_activity":    fstats.Daily,
+			"duplicate_files":   fstats.DuplicateFiles,
+			"dedup_savings":     fstats.DedupSavings,
 		})
 	}
 }
 
+// 以CSV文件形式返回用户列表
+func writeUsersCSV(ctx *gin.Context, views []UserView) {
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="users.csv"`)
+	ctx.Status(http.StatusOK)
+	w := csv.NewWriter(ctx.Writer)
+	w.Write([]string{"user_id", "friends", "file_num", "disk_used", "disk", "created_at", "last_login"})
+	for _, v := range views {
+		lastLogin := ""
+		if v.LastLogin != nil {
+			lastLogin = v.LastLogin.Format(time.RFC3339)
+		}
+		w.Write([]string{
+			v.UserID,
+			strings.Join(v.Friends, ","),
+			strconv.Itoa(v.FileNum),
+			strconv.FormatInt(v.DiskUsed, 10),
+			strconv.FormatInt(v.Disk, 10),
+			v.CreatedAt.Format(time.RFC3339),
+			lastLogin,
+		})
+	}
+	w.Flush()
+}
+
 // 获取用户的好友
 //
 // 返回:Json{"status", "friends"}
@@ -349,9 +1004,10 @@ func UserFriendsListHandler() gin.HandlerFunc {
 		fileLock.Lock()
 		defer fileLock.Unlock()
 		uid := ctx.Param("user_id")
+		annotate(ctx, "user_id", uid)
 
 		if _, ok := userMap[uid]; !ok {
-			fail(ctx, "user not exist")
+			fail(ctx, user.ErrUserNotExist)
 			return
 		}
 		friends := userMap[uid].GetFriends()
@@ -373,43 +1029,54 @@ func FileUploadHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		user_id := ctx.Param("user")
 		suffix := ctx.Param("path")
+		annotate(ctx, "user_id", user_id, "path", user_id+"/"+suffix)
+		auditEvent(ctx, "file.upload", user_id, user_id+"/"+suffix,
+			"size="+strconv.FormatInt(ctx.Request.ContentLength, 10))
+		// 按Content-Length分配内存,不接受分块上传
+		if ctx.Request.ContentLength < 0 {
+			fail(ctx, errLengthRequired)
+			return
+		}
+		if _, err := file.CleanName(suffix); err != nil {
+			fail(ctx, err)
+			return
+		}
 		userLock.Lock()
 		// 判断用户是否存在
 		u := userMap[user_id]
 		if u == nil {
 			userLock.Unlock()
-			fail(ctx, "user not exist")
+			fail(ctx, user.ErrUserNotExist)
 			return
 		}
 		userLock.Unlock()
-		if _, ok := fileMap[user_id+"/"+suffix]; ok {
-			fail(ctx, "file existed, delete firse")
+		fileLock.Lock()
+		_, ok := fileMap[user_id+"/"+suffix]
+		fileLock.Unlock()
+		if ok {
+			fail(ctx, legacy(file.ErrFileExisted, "file existed, delete firse"))
 			return
 		}
 
 		space := u.GetDisk() - u.GetUseddisk()
 		if space < ctx.Request.ContentLength {
-			fail(ctx, "no enough space")
+			fail(ctx, file.ErrNoSpace)
 			return
 		}
 
 		// 调用方法，上传文件
 		ctl := &file.FileController{}
 		ctl.SetSrv(file.FileServiceImpl{})
-		f, err := ctl.UploadFile(user_id, suffix, ctx.Request, db)
+		f, err := ctl.UploadFile(user_id, suffix, ctx.Request, reqDB(ctx))
 		if err != nil {
-			fail(ctx, err.Error())
+			fail(ctx, err)
 			return
 		}
 
 		// 上传成功，更新文件拥有者的哈希表,更新文件哈希表
 		fileLock.Lock()
 		defer fileLock.Unlock()
-		fileOwnerMap[f.Uploader] = append(fileOwnerMap[f.Uploader], f)
-		fileMap[f.Path] = f
-		u.SetUseddisk(u.GetUseddisk() + ctx.Request.ContentLength)
-		u.SetFilenum(u.GetFilenum() + 1)
-		db.Model(u).Updates(u)
+		rememberFile(u, f)
 		ctx.JSON(http.StatusOK, gin.H{
 			"status": "success",
 			// "uploader": user_id,
@@ -418,6 +1085,188 @@ func FileUploadHandler() gin.HandlerFunc {
 	}
 }
 
+// 通过POST上传ZIP或tar(.gz)压缩包,解压为用户目录下的多个文件
+//
+// URL:/file/upload-archive/用户名?prefix=目录&format=zip|tar|tar.gz
+//
+// body为压缩包的二进制,未指定format时根据文件头判断;解压后总大小超过剩余空间时不保存任何文件,
+// 已存在或文件名不合法的条目会被跳过
+//
+// 返回:Json{"status", "reason", "saved", "failed", "skipped", "entries"},
+// 新接口中部分条目未保存时HTTP状态码为207,没有保存任何文件的失败返回错误对应的状态码
+func FileUploadArchiveHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user_id := ctx.Param("user")
+		annotate(ctx, "user_id", user_id)
+		auditEvent(ctx, "file.upload_archive", user_id, user_id+"/"+ctx.Query("prefix"), ctx.Request.URL.RawQuery)
+		if ctx.Request.ContentLength < 0 {
+			fail(ctx, errLengthRequired)
+			return
+		}
+		userLock.Lock()
+		u := userMap[user_id]
+		userLock.Unlock()
+		if u == nil {
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+
+		// 每个条目保存前在fileLock下检查,同时上传的同名文件由存储层保证只写入一次
+		exists := func(path string) bool {
+			fileLock.Lock()
+			defer fileLock.Unlock()
+			_, ok := fileMap[path]
+			return ok
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		space := u.GetDisk() - u.GetUseddisk()
+		files, results, err := ctl.UploadArchive(user_id, ctx.Query("prefix"), ctx.Query("format"), space,
+			exists, ctx.Request, reqDB(ctx))
+		if len(files) > 0 {
+			fileLock.Lock()
+			for _, f := range files {
+				rememberFile(u, f)
+				// 解压中途出错时已保存的文件保留,单独记录为成功
+				e := auditEvent(ctx, "file.upload", user_id, f.Path, "archive")
+				e.Outcome = audit.OutcomeSuccess
+			}
+			fileLock.Unlock()
+		}
+		if err != nil && results == nil {
+			fail(ctx, err)
+			return
+		}
+
+		count := map[string]int{}
+		for _, r := range results {
+			count[r.Status]++
+		}
+		status, res := http.StatusOK, gin.H{"status": "success"}
+		if err != nil {
+			// 解压中途出错,已保存的文件保留
+			status, res = failResponse(ctx, err)
+		}
+		res["saved"], res["failed"], res["skipped"], res["entries"] = count["success"], count["fail"], count["skipped"], results
+		if isV2(ctx) {
+			status = resultStatus(status, count["success"], count["fail"])
+		}
+		ctx.JSON(status, res)
+	}
+}
+
+// 复制文件到用户自己的目录,可以复制分享给自己的文件,新文件计入自己的配额
+//
+// 输入:Json{"user_id", "path", "dest"},dest为新文件名,为空时使用原文件名
+//
+// 返回:Json{"status", "reason", "path"}
+func FileCopyHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg CopyMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID, "path", msg.Path)
+		e := auditEvent(ctx, "file.copy", msg.UserID, msg.Path, "")
+
+		userLock.Lock()
+		u := userMap[msg.UserID]
+		userLock.Unlock()
+		if u == nil {
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+
+		fileLock.Lock()
+		defer fileLock.Unlock()
+		f := fileMap[msg.Path]
+		if f == nil {
+			fail(ctx, file.ErrFileNotExist)
+			return
+		}
+		if len(msg.Dest) == 0 {
+			msg.Dest = f.GetName()
+		}
+		e.Detail = "dest=" + msg.Dest
+		name, err := file.CleanName(msg.Dest)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		if _, ok := fileMap[msg.UserID+"/"+name]; ok {
+			fail(ctx, legacy(file.ErrFileExisted, "file existed, delete firse"))
+			return
+		}
+		if u.GetDisk()-u.GetUseddisk() < f.GetConsume() {
+			fail(ctx, file.ErrNoSpace)
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		nf, err := ctl.CopyFile(*f, msg.UserID, name, reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		rememberFile(u, nf)
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"path":   nf.Path,
+		})
+	}
+}
+
+// 移动或重命名自己的文件,分享目标保持不变
+//
+// 输入:Json{"user_id", "path", "dest"},dest为新文件名
+//
+// 返回:Json{"status", "reason", "path"}
+func FileMoveHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg CopyMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID, "path", msg.Path)
+		auditEvent(ctx, "file.move", msg.UserID, msg.Path, "dest="+msg.Dest)
+
+		fileLock.Lock()
+		defer fileLock.Unlock()
+		f := fileMap[msg.Path]
+		if f == nil || f.Uploader != msg.UserID {
+			fail(ctx, file.ErrNotOwner)
+			return
+		}
+		name, err := file.CleanName(msg.Dest)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		if _, ok := fileMap[msg.UserID+"/"+name]; ok {
+			fail(ctx, legacy(file.ErrFileExisted, "file existed, delete firse"))
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		moved, err := ctl.MoveFile(*f, msg.UserID, name, reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		// 更新文件哈希表与上传者、分享目标可以获取的文件列表
+		replaceFile(f, moved)
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"path":   moved.Path,
+		})
+	}
+}
+
 // 更新文件的分享目标
 //
 // 输入:Json{"user_id", "target", "path"}
@@ -426,20 +1275,24 @@ func FileUploadHandler() gin.HandlerFunc {
 func FileTargetHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var msg TargetMsg
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &msg)
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID, "path", msg.Path)
+		e := auditEvent(ctx, "file.share", msg.UserID, msg.Path, "target="+msg.Target)
 		fileLock.Lock()
 		defer fileLock.Unlock()
 
 		f := fileMap[msg.Path]
 		if f == nil || f.Uploader != msg.UserID {
-			fail(ctx, "user doesn't own this file")
+			fail(ctx, file.ErrNotOwner)
 			return
 		}
 
 		u := userMap[msg.UserID]
 		if u == nil {
-			fail(ctx, "user not exist")
+			fail(ctx, user.ErrUserNotExist)
 			return
 		}
 
@@ -447,37 +1300,17 @@ func FileTargetHandler() gin.HandlerFunc {
 		ctl.SetSrv(file.FileServiceImpl{})
 
 		// 移除原本target哈希表的该文件
-		oldTarget := f.GetTarget()
-		for _, uid := range oldTarget {
-			var i int
-			for i = 0; i < len(fileOwnerMap[uid]); i++ {
-				if fileOwnerMap[uid][i].Path == msg.Path {
-					break
-				}
-			}
-			if i >= len(fileOwnerMap[uid]) {
-				continue
-			}
-			fileOwnerMap[uid] = append(fileOwnerMap[uid][:i], fileOwnerMap[uid][i+1:]...)
+		for _, uid := range f.GetTarget() {
+			removeOwned(uid, msg.Path)
 		}
 
 		// 从用户的好友列表中,获取在target中的好友
-		rawTarget := strings.Split(msg.Target, ",")
-		friends := u.GetFriends()
-		realTarget := make([]string, 0)
-		for _, t := range rawTarget {
-			if len(t) > 0 && t != u.Id {
-				for _, v := range friends {
-					if t == v {
-						realTarget = append(realTarget, t)
-						break
-					}
-				}
-			}
-		}
-		err := ctl.UpdateTarget(f, strings.Join(realTarget, ","), db)
+		realTarget := friendTargets(u, msg.Target)
+		// 记录实际生效的分享目标
+		e.Detail = "target=" + strings.Join(realTarget, ",")
+		err := ctl.UpdateTarget(f, strings.Join(realTarget, ","), reqDB(ctx))
 		if err != nil {
-			fail(ctx, err.Error())
+			fail(ctx, err)
 			return
 		}
 
@@ -492,6 +1325,157 @@ func FileTargetHandler() gin.HandlerFunc {
 	}
 }
 
+// 搜索用户可以下载的文件
+//
+// 参数:user_id, name, match(substring/prefix/glob), uploader, category, tags,
+// min_size, max_size, after, before, offset, limit
+//
+// 返回:Json{"status", "files"}
+func FileSearchHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uid := ctx.Query("user_id")
+		annotate(ctx, "user_id", uid)
+		userLock.Lock()
+		u := userMap[uid]
+		userLock.Unlock()
+		if u == nil {
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+
+		q := file.SearchQuery{
+			Name:     ctx.Query("name"),
+			Match:    ctx.Query("match"),
+			Uploader: ctx.Query("uploader"),
+			Category: ctx.Query("category"),
+		}
+		if tags := ctx.Query("tags"); len(tags) > 0 {
+			q.Tags = strings.Split(strings.ToLower(tags), ",")
+		}
+		var err error
+		if q.MinSize, err = queryInt64(ctx, "min_size"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if q.MaxSize, err = queryInt64(ctx, "max_size"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if q.After, err = queryTime(ctx, "after"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if q.Before, err = queryTime(ctx, "before"); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if q.Limit, err = queryLimit(ctx, 50, 500); err != nil {
+			fail(ctx, err)
+			return
+		}
+		if s := ctx.Query("offset"); len(s) > 0 {
+			if q.Offset, err = strconv.Atoi(s); err != nil || q.Offset < 0 {
+				fail(ctx, invalidParam("invalid offset"))
+				return
+			}
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		files, err := ctl.SearchFiles(uid, q, reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		res := make([]FileInfo, 0, len(files))
+		for i := range files {
+			res = append(res, fileInfo(&files[i], uid))
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"files":  res,
+		})
+	}
+}
+
+// 全文搜索用户可以下载的文本文件
+//
+// 参数:user_id, q, limit
+//
+// 返回:Json{"status", "files":[{"file_path", "score", "snippet"}]}
+func FileFullTextHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uid, q := ctx.Query("user_id"), ctx.Query("q")
+		annotate(ctx, "user_id", uid)
+		limit, err := queryLimit(ctx, 20, 100)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		if len(strings.TrimSpace(q)) == 0 {
+			fail(ctx, invalidParam("empty query"))
+			return
+		}
+
+		// 可访问的文件即为用户可下载的文件列表
+		fileLock.Lock()
+		if userMap[uid] == nil {
+			fileLock.Unlock()
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+		allowed := make(map[string]bool, len(fileOwnerMap[uid]))
+		for _, f := range fileOwnerMap[uid] {
+			allowed[f.GetPath()] = f.Accessible(uid)
+		}
+		fileLock.Unlock()
+
+		hits := textIndexer.Index().Search(q, func(p string) bool {
+			return allowed[p]
+		}, limit)
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"files":  hits,
+		})
+	}
+}
+
+// 更新文件标签,仅上传者可以修改
+//
+// 输入:Json{"user_id", "path", "tags"}
+//
+// 返回:Json{"status", "reason"/"tags"}
+func FileTagsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg TagMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID, "path", msg.Path)
+		fileLock.Lock()
+		defer fileLock.Unlock()
+
+		f := fileMap[msg.Path]
+		if f == nil || f.GetUploader() != msg.UserID {
+			fail(ctx, file.ErrNotOwner)
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		err := ctl.UpdateTags(f, strings.Split(msg.Tags, ","), reqDB(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"tags":   f.GetTags(),
+		})
+	}
+}
+
 func FileOwnerHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, gin.H{
@@ -509,22 +1493,167 @@ func FileOwnerHandler() gin.HandlerFunc {
 func FileDownloadHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var msg TargetMsg
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &msg)
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID, "path", msg.Path)
+		auditEvent(ctx, "file.download", msg.UserID, msg.Path, "")
 
+		// 复制文件信息后释放锁,发送文件内容时不持有fileLock
+		fileLock.Lock()
 		f := fileMap[msg.Path]
+		var cp file.File
+		if f != nil {
+			cp = *f
+		}
+		fileLock.Unlock()
+		if f == nil {
+			fail(ctx, file.ErrFileNotExist)
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		err := ctl.DownloadFile(&cp, msg.UserID, ctx)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		if err = file.RecordActivity(file.ActivityDownload, msg.UserID, cp.GetPath(), cp.GetConsume(), reqDB(ctx)); err != nil {
+			reqLog(ctx).Warn("record download failed", "err", err)
+		}
+	}
+}
+
+// 预览文本文件,Markdown文件同时返回渲染后的HTML
+//
+// 参数:user_id, path, limit(读取的KB数,默认64,最大1024)
+//
+// 返回:Json{"status", "reason", "preview": {"encoding", "language", "size", "truncated", "content", "html"}}
+func FilePreviewHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		annotate(ctx, "user_id", ctx.Query("user_id"), "path", ctx.Query("path"))
+		limit := int64(file.DefaultPreviewBytes >> 10)
+		if v, err := queryInt64(ctx, "limit"); err != nil {
+			fail(ctx, err)
+			return
+		} else if v != nil {
+			limit = *v
+		}
+		if limit <= 0 || limit > file.MaxPreviewBytes>>10 {
+			fail(ctx, invalidParam("invalid limit"))
+			return
+		}
+
+		fileLock.Lock()
+		f := fileMap[ctx.Query("path")]
+		var cp file.File
+		if f != nil {
+			cp = *f
+		}
+		fileLock.Unlock()
 		if f == nil {
-			fail(ctx, "file not exist")
+			fail(ctx, file.ErrFileNotExist)
 			return
 		}
 
 		ctl := &file.FileController{}
 		ctl.SetSrv(file.FileServiceImpl{})
-		err := ctl.DownloadFile(f, msg.UserID, ctx)
+		preview, err := ctl.Preview(ctx.Request.Context(), &cp, ctx.Query("user_id"), int(limit<<10))
 		if err != nil {
-			fail(ctx, err.Error())
+			fail(ctx, err)
 			return
 		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"preview": preview,
+		})
+	}
+}
+
+// 打包下载多个文件
+//
+// 输入:Json{"user_id", "paths", "folder", "format"},folder为路径前缀,
+// 会打包该前缀下用户可以下载的所有文件;format为zip(默认)或tar.gz
+//
+// 输出:压缩包二进制流,无权访问或不存在的文件列在压缩包的_skipped.txt中
+func FileArchiveHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg ArchiveMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID)
+		e := auditEvent(ctx, "file.archive", msg.UserID, msg.Folder, "")
+		if len(msg.Format) == 0 {
+			msg.Format = file.ArchiveZip
+		}
+		if msg.Format != file.ArchiveZip && msg.Format != file.ArchiveTarGz {
+			fail(ctx, file.ErrInvalidArchive)
+			return
+		}
+
+		// 复制文件信息后释放锁,打包过程中不持有fileLock
+		fileLock.Lock()
+		if userMap[msg.UserID] == nil {
+			fileLock.Unlock()
+			fail(ctx, user.ErrUserNotExist)
+			return
+		}
+		files := make([]file.File, 0)
+		skipped := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, p := range msg.Paths {
+			f := fileMap[p]
+			if f == nil {
+				skipped = append(skipped, p+": file not exist")
+				continue
+			}
+			if !seen[p] {
+				seen[p] = true
+				files = append(files, *f)
+			}
+		}
+		if len(msg.Folder) > 0 {
+			prefix := strings.TrimSuffix(msg.Folder, "/") + "/"
+			for _, f := range fileOwnerMap[msg.UserID] {
+				if strings.HasPrefix(f.GetPath(), prefix) && !seen[f.GetPath()] {
+					seen[f.GetPath()] = true
+					files = append(files, *f)
+				}
+			}
+		}
+		fileLock.Unlock()
+
+		if len(files) == 0 {
+			fail(ctx, file.ErrNothingToExport)
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		res, err := ctl.DownloadArchive(files, msg.UserID, msg.Format, skipped, ctx)
+		if err != nil {
+			// 响应已经开始发送,只能记录错误
+			reqLog(ctx).Error("stream archive failed", "err", err)
+			e.Outcome, e.Reason = audit.OutcomeFail, err.Error()
+		}
+		// 记录实际写入压缩包的文件
+		e.Detail = pathsDetail(res.Written)
+		written := make(map[string]bool, len(res.Written))
+		for _, p := range res.Written {
+			written[p] = true
+		}
+		for i := range files {
+			if !written[files[i].GetPath()] {
+				continue
+			}
+			if err = file.RecordActivity(file.ActivityDownload, msg.UserID, files[i].GetPath(), files[i].GetConsume(), reqDB(ctx)); err != nil {
+				reqLog(ctx).Warn("record download failed", "err", err)
+			}
+		}
 	}
 }
 
@@ -536,58 +1665,51 @@ func FileDownloadHandler() gin.HandlerFunc {
 func FileDeleteHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var msg TargetMsg
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		json.Unmarshal(body, &msg)
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+		annotate(ctx, "user_id", msg.UserID, "path", msg.Path)
+		auditEvent(ctx, "file.delete", msg.UserID, msg.Path, "")
 
 		// 检验用户参数
+		userLock.Lock()
 		u := userMap[msg.UserID]
+		userLock.Unlock()
 		if u == nil {
-			fail(ctx, "user not exist")
+			fail(ctx, user.ErrUserNotExist)
 			return
 		}
 
-		// 检验文件参数
+		// 检验文件参数,复制文件信息后释放锁
+		fileLock.Lock()
 		f := fileMap[msg.Path]
+		var cp file.File
+		if f != nil {
+			cp = *f
+		}
+		fileLock.Unlock()
 		if f == nil {
-			fail(ctx, "file not exist")
+			fail(ctx, file.ErrFileNotExist)
 			return
 		}
 
 		// 调用删除服务
 		ctl := &file.FileController{}
 		ctl.SetSrv(file.FileServiceImpl{})
-		err := ctl.DeleteFile(f, msg.UserID, db)
+		err := ctl.DeleteFile(&cp, msg.UserID, reqDB(ctx))
 		if err != nil {
-			fail(ctx, err.Error())
+			fail(ctx, err)
 			return
 		}
 
 		fileLock.Lock()
 		defer fileLock.Unlock()
 
-		// 从内存中的Map删除
-		target := f.GetTarget()
-		target = append(target, f.GetUploader())
-		for _, uid := range target {
-			var i int
-			for i = 0; i < len(fileOwnerMap[uid]); i++ {
-				if fileOwnerMap[uid][i].Path == msg.Path {
-					break
-				}
-			}
-			if i >= len(fileOwnerMap[uid]) {
-				continue
-			}
-			fileOwnerMap[uid] = append(fileOwnerMap[uid][:i], fileOwnerMap[uid][i+1:]...)
+		// 从内存中删除并更新上传者用量,期间文件可能已被移动或替换
+		if mf := fileMap[cp.GetPath()]; mf != nil && mf.ID == cp.ID {
+			forgetFile(mf)
 		}
-
-		// 更新上传者的文件数和空间
-		u.SetUseddisk(u.GetUseddisk() - f.GetConsume())
-		u.SetFilenum(u.GetFilenum() - 1)
-		db.Model(u).Updates(u)
-
-		// 从内存中删除
-		delete(fileMap, f.GetPath())
 		ctx.JSON(http.StatusOK, gin.H{
 			"status": "success",
 			"reason": msg.Path,
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// 传输类型
+const (
+	transferUpload   = "upload"
+	transferDownload = "download"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netdisk_http_requests_total",
+		Help: "HTTP请求数",
+	}, []string{"group", "route", "method", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netdisk_http_request_duration_seconds",
+		Help:    "HTTP请求耗时",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"group", "route"})
+
+	transferBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netdisk_transfer_bytes_total",
+		Help: "上传、下载的字节数",
+	}, []string{"kind"})
+	transferDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netdisk_transfer_duration_seconds",
+		Help:    "上传、下载耗时",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"kind"})
+	activeTransfers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netdisk_active_transfers",
+		Help: "正在进行的上传、下载数",
+	}, []string{"kind"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netdisk_db_query_duration_seconds",
+		Help:    "数据库操作耗时",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+	}, []string{"op"})
+	dbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netdisk_db_errors_total",
+		Help: "数据库操作错误数,不含记录不存在",
+	}, []string{"op"})
+
+	lockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netdisk_lock_wait_seconds",
+		Help:    "等待全局锁的时间",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"lock"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, transferBytes, transferDuration,
+		activeTransfers, dbDuration, dbErrors, lockWait)
+
+	// 用户数、文件数与存储用量在抓取时从内存中统计
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "netdisk_users",
+		Help: "用户数",
+	}, func() float64 {
+		userLock.Lock()
+		defer userLock.Unlock()
+		return float64(len(userMap))
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "netdisk_files",
+		Help: "文件数",
+	}, func() float64 {
+		fileLock.Lock()
+		defer fileLock.Unlock()
+		return float64(len(fileMap))
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "netdisk_storage_used_bytes",
+		Help: "所有用户已用的配额",
+	}, func() float64 {
+		userLock.Lock()
+		defer userLock.Unlock()
+		var used int64
+		for _, u := range userMap {
+			used += u.GetUseddisk()
+		}
+		return float64(used)
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "netdisk_storage_stored_bytes",
+		Help: "文件在磁盘上的实际占用空间",
+	}, func() float64 {
+		fileLock.Lock()
+		defer fileLock.Unlock()
+		var stored int64
+		for _, f := range fileMap {
+			stored += f.GetStored()
+		}
+		return float64(stored)
+	}))
+}
+
+// 记录等待时间的互斥锁
+type timedMutex struct {
+	sync.Mutex
+	wait prometheus.Observer
+}
+
+func newTimedMutex(name string) *timedMutex {
+	return &timedMutex{wait: lockWait.WithLabelValues(name)}
+}
+
+func (m *timedMutex) Lock() {
+	start := time.Now()
+	m.Mutex.Lock()
+	m.wait.Observe(time.Since(start).Seconds())
+}
+
+// 按路由分组统计请求数与耗时
+func requestMetrics() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		route := ctx.FullPath()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		// 新旧接口按相同的分组统计,route中保留/v2前缀
+		group := strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(route, "/v2"), "/"), "/", 2)[0]
+		httpRequests.WithLabelValues(group, route, ctx.Request.Method, strconv.Itoa(ctx.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(group, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// 统计读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// 统计上传、下载的字节数、耗时与进行中的数量
+func transferMetrics(kind string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		active := activeTransfers.WithLabelValues(kind)
+		active.Inc()
+		defer active.Dec()
+		start := time.Now()
+		body := &countingReader{ReadCloser: ctx.Request.Body}
+		ctx.Request.Body = body
+		ctx.Next()
+
+		n := body.n
+		if kind == transferDownload {
+			n = int64(ctx.Writer.Size())
+		}
+		if n > 0 {
+			transferBytes.WithLabelValues(kind).Add(float64(n))
+		}
+		transferDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	}
+}
+
+// 返回Prometheus格式的指标
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// 数据库回调中记录开始时间的键
+const dbStartKey = "metrics:start"
+
+// 注册数据库回调,统计各类操作的耗时与错误数
+func instrumentDB(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(dbStartKey, time.Now())
+	}
+	after := func(op string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if v, ok := tx.InstanceGet(dbStartKey); ok {
+				dbDuration.WithLabelValues(op).Observe(time.Since(v.(time.Time)).Seconds())
+			}
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				dbErrors.WithLabelValues(op).Inc()
+			}
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 接口文档,修改路由或请求、响应的结构体时需要同步修改,TestOpenAPI检查两者是否一致
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// 返回接口文档
+func OpenAPIHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", openapiSpec)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"audit"
+	"client"
+	"encoding/json"
+	"errors"
+	"file"
+	"fmt"
+	"reflect"
+	"regexp"
+	"search"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 接口文档与已注册的路由、请求响应结构体以及客户端必须一致
+func TestOpenAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if err := checkOpenAPI(newRouter().Routes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 与接口文档中同名schema对应的请求、响应结构体
+var openapiSchemas = map[string]any{
+	"RawUser":        RawUser{},
+	"RegisterMsg":    RegisterMsg{},
+	"FriendMsg":      FriendMsg{},
+	"DeleteMsg":      DeleteMsg{},
+	"TargetMsg":      TargetMsg{},
+	"CopyMsg":        CopyMsg{},
+	"ArchiveMsg":     ArchiveMsg{},
+	"TagMsg":         TagMsg{},
+	"QueryMsg":       QueryMsg{},
+	"ReconcileMsg":   ReconcileMsg{},
+	"GCMsg":          GCMsg{},
+	"RotateKeyMsg":   RotateKeyMsg{},
+	"BatchOp":        BatchOp{},
+	"BatchMsg":       BatchMsg{},
+	"FieldError":     FieldError{},
+	"SimpleFile":     SimpleFile{},
+	"FileInfo":       FileInfo{},
+	"UserView":       UserView{},
+	"Discrepancy":    Discrepancy{},
+	"DanglingRecord": DanglingRecord{},
+	"GCReport":       GCReport{},
+	"ScrubReport":    ScrubReport{},
+	"BatchResult":    BatchResult{},
+	"OrphanBlob":     file.OrphanBlob{},
+	"ExtractResult":  file.ExtractResult{},
+	"Preview":        file.Preview{},
+	"SizeBucket":     file.SizeBucket{},
+	"TypeCount":      file.TypeCount{},
+	"DailyActivity":  file.DailyActivity{},
+	"SearchHit":      search.Hit{},
+	"AuditEntry":     audit.Entry{},
+}
+
+// 接口文档中用到的部分
+type apiSpec struct {
+	Paths      map[string]map[string]apiOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]apiSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type apiOperation struct {
+	OperationID string `json:"operationId"`
+	// 只在根路径下提供的接口设置了servers
+	Servers    []struct{} `json:"servers"`
+	Parameters []struct {
+		Name string `json:"name"`
+		In   string `json:"in"`
+	} `json:"parameters"`
+}
+
+type apiSchema struct {
+	Required   []string                   `json:"required"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// gin路由中的路径参数
+var routeParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// 检查接口文档与已注册的路由、结构体以及客户端是否一致
+//
+// 每个路由都有对应的接口,反之亦然;结构体的Json字段与schema的属性相同,binding为required的字段在schema中也是必需的;
+// 客户端实现了所有接口,查询参数与schema都与文档相同
+func checkOpenAPI(routes gin.RoutesInfo) error {
+	var spec apiSpec
+	if err := json.Unmarshal(openapiSpec, &spec); err != nil {
+		return fmt.Errorf("%w when parsing openapi.json", err)
+	}
+	var errs []error
+
+	// 文档中的接口,键为"方法 路径"
+	ops := make(map[string]apiOperation)
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			ops[strings.ToUpper(method)+" "+path] = op
+		}
+	}
+	routed := make(map[string]bool)
+	for _, r := range routes {
+		path := r.Path
+		v2 := strings.HasPrefix(path, "/v2/")
+		if v2 {
+			path = strings.TrimPrefix(path, "/v2")
+		}
+		key := r.Method + " " + routeParam.ReplaceAllString(path, "{$1}")
+		op, ok := ops[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("route %v %v is not documented", r.Method, r.Path))
+			continue
+		}
+		if v2 && len(op.Servers) > 0 {
+			errs = append(errs, fmt.Errorf("route %v %v is documented as root only", r.Method, r.Path))
+		}
+		routed[key] = true
+	}
+	for key := range ops {
+		if !routed[key] {
+			errs = append(errs, fmt.Errorf("operation %v has no route", key))
+		}
+	}
+
+	for name, v := range openapiSchemas {
+		errs = append(errs, checkSchema(spec, name, reflect.TypeOf(v)))
+	}
+	for name := range spec.Components.Schemas {
+		v, ok := client.Schemas[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("client has no type for schema %v", name))
+			continue
+		}
+		errs = append(errs, checkSchema(spec, name, reflect.TypeOf(v)))
+	}
+
+	implemented := make(map[string]bool)
+	for _, c := range client.Operations {
+		key := c.Method + " " + c.Path
+		op, ok := ops[key]
+		if !ok || op.OperationID != c.ID {
+			errs = append(errs, fmt.Errorf("client operation %v (%v) is not documented", c.ID, key))
+			continue
+		}
+		if c.Root != (len(op.Servers) > 0) {
+			errs = append(errs, fmt.Errorf("client operation %v: root mismatch", c.ID))
+		}
+		var query []string
+		for _, p := range op.Parameters {
+			if p.In == "query" {
+				query = append(query, p.Name)
+			}
+		}
+		var fields []string
+		if c.Query != nil {
+			t := reflect.TypeOf(c.Query)
+			for i := 0; i < t.NumField(); i++ {
+				if name := t.Field(i).Tag.Get("query"); len(name) > 0 {
+					fields = append(fields, name)
+				}
+			}
+		}
+		if diff := symmetricDiff(query, fields); len(diff) > 0 {
+			errs = append(errs, fmt.Errorf("client operation %v: query parameters differ: %v", c.ID, diff))
+		}
+		implemented[key] = true
+	}
+	for key, op := range ops {
+		if !implemented[key] {
+			errs = append(errs, fmt.Errorf("client does not implement operation %v", op.OperationID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 检查结构体与schema的字段是否一致
+func checkSchema(spec apiSpec, name string, t reflect.Type) error {
+	s, ok := spec.Components.Schemas[name]
+	if !ok {
+		return fmt.Errorf("schema %v is not documented", name)
+	}
+	var props []string
+	for p := range s.Properties {
+		props = append(props, p)
+	}
+	fields, required := jsonFields(t)
+	if diff := symmetricDiff(props, fields); len(diff) > 0 {
+		return fmt.Errorf("schema %v: properties differ from %v: %v", name, t, diff)
+	}
+	for _, f := range required {
+		if !contains(s.Required, f) {
+			return fmt.Errorf("schema %v: %v is required by %v", name, f, t)
+		}
+	}
+	return nil
+}
+
+// 结构体的Json字段名,以及binding为required的字段
+func jsonFields(t reflect.Type) (fields, required []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && len(name) == 0 && f.Type.Kind() == reflect.Struct {
+			fs, rs := jsonFields(f.Type)
+			fields, required = append(fields, fs...), append(required, rs...)
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields = append(fields, name)
+		if strings.SplitN(f.Tag.Get("binding"), ",", 2)[0] == "required" {
+			required = append(required, name)
+		}
+	}
+	return fields, required
+}
+
+// 只在其中一个列表中出现的元素,排序后返回
+func symmetricDiff(a, b []string) []string {
+	var res []string
+	for _, s := range a {
+		if !contains(b, s) {
+			res = append(res, s)
+		}
+	}
+	for _, s := range b {
+		if !contains(a, s) {
+			res = append(res, s)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
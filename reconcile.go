@@ -0,0 +1,183 @@
+package main
+
+import (
+	"file"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"user"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReconcileMsg struct {
+	// 逗号分隔的用户,为空时核对所有用户
+	UserID string `json:"user_id" binding:"max=4096"`
+	Fix    bool   `json:"fix"`
+}
+
+// 单个用户的用量核对结果
+type Discrepancy struct {
+	UserID string `json:"user_id"`
+	// 用户记录中的文件数与已用空间
+	Filenum  int   `json:"file_num"`
+	Diskused int64 `json:"disk_used"`
+	// 根据文件记录计算的文件数与占用的配额
+	ActualFilenum int   `json:"actual_file_num"`
+	RecordedBytes int64 `json:"recorded_bytes"`
+	// 磁盘上文件的原始大小与实际占用空间
+	DiskBytes   int64 `json:"disk_bytes"`
+	StoredBytes int64 `json:"stored_bytes"`
+	// 磁盘上不存在或无法读取的文件
+	MissingFiles []string `json:"missing_files"`
+	// 记录大小与磁盘大小不一致的文件
+	SizeMismatch []string `json:"size_mismatch"`
+	Fixed        bool     `json:"fixed"`
+}
+
+// 重新计算用户的文件数与已用空间,返回存在差异的用户
+//
+// uids为空时核对所有用户;fix为true时以磁盘上的实际大小修正文件记录与用户用量
+func reconcile(uids []string, fix bool) ([]Discrepancy, error) {
+	userLock.Lock()
+	users := make([]*user.User, 0, len(userMap))
+	if len(uids) == 0 {
+		for _, u := range userMap {
+			users = append(users, u)
+		}
+	} else {
+		for _, uid := range uids {
+			if u := userMap[uid]; u != nil {
+				users = append(users, u)
+			}
+		}
+	}
+	userLock.Unlock()
+
+	// 持有文件锁,防止核对期间有上传或删除修改用量
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	res := make([]Discrepancy, 0)
+	for _, u := range users {
+		var files []file.File
+		if err := db.Where("file_uploader = ?", u.GetId()).Find(&files).Error; err != nil {
+			return res, err
+		}
+
+		d := Discrepancy{
+			UserID:        u.GetId(),
+			Filenum:       u.GetFilenum(),
+			Diskused:      u.GetUseddisk(),
+			ActualFilenum: len(files),
+			MissingFiles:  make([]string, 0),
+			SizeMismatch:  make([]string, 0),
+		}
+		sizes := make(map[string][2]int64, len(files))
+		for i := range files {
+			f := &files[i]
+			d.RecordedBytes += f.Charge()
+			size, err := file.ContentSize(f)
+			if err != nil {
+				d.MissingFiles = append(d.MissingFiles, f.GetPath())
+				continue
+			}
+			stored, err := file.StoredSize(f)
+			if err != nil {
+				d.MissingFiles = append(d.MissingFiles, f.GetPath())
+				continue
+			}
+			d.DiskBytes += size
+			d.StoredBytes += stored
+			if size != f.GetConsume() || stored != f.GetStored() {
+				d.SizeMismatch = append(d.SizeMismatch, f.GetPath())
+				sizes[f.GetPath()] = [2]int64{size, stored}
+			}
+		}
+
+		if d.Filenum == d.ActualFilenum && d.Diskused == d.RecordedBytes &&
+			len(d.MissingFiles) == 0 && len(d.SizeMismatch) == 0 {
+			continue
+		}
+		if fix {
+			if err := repairUsage(u, files, sizes); err != nil {
+				return res, err
+			}
+			d.Fixed = true
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// 以磁盘大小修正文件记录,再根据文件记录修正用户用量
+//
+// 磁盘上缺失的文件不在此处理,由垃圾回收负责
+//
+// sizes记录需要修正的文件的原始大小与实际占用空间
+func repairUsage(u *user.User, files []file.File, sizes map[string][2]int64) error {
+	var used int64
+	for i := range files {
+		f := &files[i]
+		if size, ok := sizes[f.GetPath()]; ok {
+			if err := f.SetConsume(size[0], db); err != nil {
+				return err
+			}
+			if err := f.SetStored(size[1], db); err != nil {
+				return err
+			}
+			if mf := fileMap[f.GetPath()]; mf != nil {
+				mf.Consume, mf.Stored = size[0], size[1]
+			}
+		}
+		used += f.Charge()
+	}
+	u.SetFilenum(len(files))
+	u.SetUseddisk(used)
+	return u.SaveUsage(db)
+}
+
+// 定期核对用户用量,只记录差异不做修正
+func reconcileLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		res, err := reconcile(nil, false)
+		if err != nil {
+			slog.Error("reconcile usage failed", "err", err)
+			continue
+		}
+		for _, d := range res {
+			slog.Warn("usage discrepancy", "user_id", d.UserID, "file_num", d.Filenum, "actual_file_num", d.ActualFilenum,
+				"disk_used", d.Diskused, "recorded_bytes", d.RecordedBytes)
+		}
+	}
+}
+
+// 管理员核对并修复用户用量
+//
+// 输入:Json{"user_id", "fix"},user_id为逗号分隔的用户列表,为空时核对所有用户
+//
+// 输出:Json{"status", "discrepancies"}
+func ManagerReconcileHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var msg ReconcileMsg
+		if err := bindJSON(ctx, &msg); err != nil {
+			fail(ctx, err)
+			return
+		}
+
+		var uids []string
+		if len(msg.UserID) > 0 {
+			uids = strings.Split(msg.UserID, ",")
+		}
+		res, err := reconcile(uids, msg.Fix)
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":        "success",
+			"discrepancies": res,
+		})
+	}
+}
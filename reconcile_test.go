@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	cases := []struct {
+		name string
+		// 修改内存或磁盘上的状态,使之与文件记录不一致
+		setup    func(t *testing.T, fake *fakeDB)
+		fix      bool
+		want     bool
+		missing  int
+		mismatch int
+		// 修复后用户的文件数与已用空间
+		filenum int
+		used    int64
+	}{
+		{"consistent", func(*testing.T, *fakeDB) {}, false, false, 0, 0, 2, 11},
+		{"stale usage", func(*testing.T, *fakeDB) {
+			userMap["alice"].SetUseddisk(100)
+			userMap["alice"].SetFilenum(5)
+		}, false, true, 0, 0, 5, 100},
+		{"stale usage fixed", func(*testing.T, *fakeDB) {
+			userMap["alice"].SetUseddisk(100)
+			userMap["alice"].SetFilenum(5)
+		}, true, true, 0, 0, 2, 11},
+		{"missing blob", func(t *testing.T, _ *fakeDB) {
+			if err := os.Remove(fileMap["alice/a.txt"].StoragePath()); err != nil {
+				t.Fatal(err)
+			}
+		}, true, true, 1, 0, 2, 11},
+		{"size mismatch fixed", func(t *testing.T, _ *fakeDB) {
+			if err := os.WriteFile(fileMap["alice/b.txt"].StoragePath(), []byte("grown content"), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}, true, true, 0, 1, 2, 18},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := useTestServer(t)
+			alice := addTestUser("alice", 1<<20)
+			a := addTestFile(t, alice, 1, "a.txt", "hello")
+			b := addTestFile(t, alice, 2, "b.txt", "world!")
+			fake.files = append(fake.files, *a, *b)
+			bob := addTestUser("bob", 1<<20)
+			fake.files = append(fake.files, *addTestFile(t, bob, 3, "c.txt", "bob"))
+			c.setup(t, fake)
+
+			res, err := reconcile([]string{"alice"}, c.fix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.want {
+				if len(res) != 0 {
+					t.Fatalf("discrepancies = %+v", res)
+				}
+				return
+			}
+			if len(res) != 1 || res[0].UserID != "alice" {
+				t.Fatalf("discrepancies = %+v", res)
+			}
+			d := res[0]
+			if d.ActualFilenum != 2 || d.Fixed != c.fix || len(d.MissingFiles) != c.missing || len(d.SizeMismatch) != c.mismatch {
+				t.Fatalf("discrepancy = %+v", d)
+			}
+			if alice.GetFilenum() != c.filenum || alice.GetUseddisk() != c.used {
+				t.Fatalf("usage = %v files, %v bytes, want %v files, %v bytes", alice.GetFilenum(), alice.GetUseddisk(), c.filenum, c.used)
+			}
+			if saved := fake.count("UPDATE `users`") > 0; saved != c.fix {
+				t.Fatalf("usage saved = %v", saved)
+			}
+			if c.mismatch > 0 && fileMap["alice/b.txt"].GetConsume() != 13 {
+				t.Fatalf("consume = %v", fileMap["alice/b.txt"].GetConsume())
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"file"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 同一时间只允许一次校验
+var scrubLock sync.Mutex
+
+type ScrubReport struct {
+	Checked int      `json:"checked"`
+	Corrupt []string `json:"corrupt"`
+	Errors  []string `json:"errors"`
+}
+
+// 已经有校验任务在运行
+var errScrubRunning = errors.New("scrub already running")
+
+// 重新计算所有文件的校验和,标记内容损坏的文件
+//
+// 计算校验和时不持有fileLock,避免长时间阻塞上传下载
+func scrubFiles() (ScrubReport, error) {
+	report := ScrubReport{Corrupt: make([]string, 0), Errors: make([]string, 0)}
+	if !scrubLock.TryLock() {
+		return report, errScrubRunning
+	}
+	defer scrubLock.Unlock()
+
+	fileLock.Lock()
+	files := make([]file.File, 0, len(fileMap))
+	for _, f := range fileMap {
+		files = append(files, *f)
+	}
+	fileLock.Unlock()
+
+	ctl := &file.FileController{}
+	ctl.SetSrv(file.FileServiceImpl{})
+	for _, f := range files {
+		res, err := ctl.Scrub(f, db)
+		if err != nil {
+			// 磁盘文件缺失由垃圾回收处理
+			if !os.IsNotExist(err) {
+				report.Errors = append(report.Errors, fmt.Sprintf("%v: %v", f.GetPath(), err))
+			}
+			continue
+		}
+		report.Checked++
+
+		fileLock.Lock()
+		if mf := fileMap[f.GetPath()]; mf != nil && mf.ID == f.ID {
+			mf.ApplyScrub(res)
+		}
+		fileLock.Unlock()
+
+		if res.Corrupt {
+			slog.Error("file corrupted", "path", f.GetPath(), "sha256", res.SHA256)
+			report.Corrupt = append(report.Corrupt, f.GetPath())
+		}
+	}
+	return report, nil
+}
+
+// 定期校验文件内容
+func scrubLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := scrubFiles()
+		if err != nil {
+			slog.Error("scrub files failed", "err", err)
+			continue
+		}
+		slog.Info("files scrubbed", "checked", report.Checked, "corrupt", len(report.Corrupt), "errors", len(report.Errors))
+	}
+}
+
+// 管理员立即校验所有文件
+//
+// 输出:Json{"status", "report"}
+func ManagerScrubHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		report, err := scrubFiles()
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"report": report,
+		})
+	}
+}
+
+// 管理员查看已标记损坏的文件
+//
+// 输出:Json{"status", "files"}
+func ManagerCorruptHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		fileLock.Lock()
+		defer fileLock.Unlock()
+		res := make([]gin.H, 0)
+		for _, f := range fileMap {
+			if f.Corrupt {
+				res = append(res, gin.H{
+					"file_path":  f.GetPath(),
+					"uploader":   f.GetUploader(),
+					"sha256":     f.SHA256,
+					"checked_at": f.CheckedAt,
+				})
+			}
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"files":  res,
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 每个文件保留用于生成摘要的文本字节数
+const maxSnippetText = 64 << 10
+
+// 单个文件的索引数据
+type document struct {
+	// 文本开头部分,用于生成摘要
+	text  string
+	terms map[string]int
+}
+
+// 搜索结果
+type Hit struct {
+	Path    string `json:"file_path"`
+	Score   int    `json:"score"`
+	Snippet string `json:"snippet"`
+}
+
+// 内存中的倒排索引
+type Index struct {
+	lock     sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]struct{}
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// 判断是否为需要按字切分的中日韩字符
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// 分词:字母数字按单词切分,中日韩文字按字和相邻二元组切分
+//
+// 查询时连续的中日韩文字只使用二元组,单个字时使用该字
+func tokenize(text string, query bool) []string {
+	tokens := make([]string, 0)
+	word := make([]rune, 0)
+	run := make([]rune, 0)
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+		if query && len(run) == 1 {
+			tokens = append(tokens, string(run))
+		}
+		run = run[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			if len(word) > 0 {
+				tokens = append(tokens, string(word))
+				word = word[:0]
+			}
+			if !query {
+				tokens = append(tokens, string(r))
+			}
+			if len(run) > 0 {
+				tokens = append(tokens, string([]rune{run[len(run)-1], r}))
+			}
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(run) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// 添加或替换文件的索引
+func (idx *Index) Add(path, text string) {
+	doc := &document{text: truncate(text, maxSnippetText), terms: make(map[string]int)}
+	for _, t := range tokenize(text, false) {
+		doc.terms[t]++
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.remove(path)
+	idx.docs[path] = doc
+	for t := range doc.terms {
+		if idx.postings[t] == nil {
+			idx.postings[t] = make(map[string]struct{})
+		}
+		idx.postings[t][path] = struct{}{}
+	}
+}
+
+// 删除文件的索引
+func (idx *Index) Remove(path string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.remove(path)
+}
+
+func (idx *Index) remove(path string) {
+	doc := idx.docs[path]
+	if doc == nil {
+		return
+	}
+	for t := range doc.terms {
+		delete(idx.postings[t], path)
+		if len(idx.postings[t]) == 0 {
+			delete(idx.postings, t)
+		}
+	}
+	delete(idx.docs, path)
+}
+
+// 已索引的文件数量
+func (idx *Index) Len() int {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return len(idx.docs)
+}
+
+// 搜索同时包含所有查询词的文件
+//
+// allowed用于过滤调用者无权访问的文件,结果按得分降序排列
+func (idx *Index) Search(query string, allowed func(path string) bool, limit int) []Hit {
+	terms := tokenize(query, true)
+	hits := make([]Hit, 0)
+	if len(terms) == 0 {
+		return hits
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	// 从文档数最少的查询词开始求交集
+	sort.Slice(terms, func(i, j int) bool {
+		return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]])
+	})
+	for path := range idx.postings[terms[0]] {
+		if !allowed(path) {
+			continue
+		}
+		doc := idx.docs[path]
+		score := 0
+		for _, t := range terms {
+			n := doc.terms[t]
+			if n == 0 {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score == 0 {
+			continue
+		}
+		hits = append(hits, Hit{Path: path, Score: score, Snippet: snippet(doc, terms)})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Path < hits[j].Path
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// 截断文本到最多n字节,不截断在多字节字符中间
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		// 复制一份,不引用调用者的大块文本
+		return strings.Clone(text)
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return strings.Clone(text[:n])
+}
+
+// 截取查询词首次出现位置附近的文本作为摘要
+func snippet(doc *document, terms []string) string {
+	const radius = 40
+	text := doc.text
+	lower := strings.ToLower(text)
+	// 个别字符转小写后长度会变化,此时直接使用小写文本
+	if len(lower) != len(text) {
+		text = lower
+	}
+	pos := -1
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		pos = 0
+	}
+
+	// 向前、向后各取radius个字符
+	start, end := pos, pos
+	for i := 0; i < radius && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	for i := 0; i < radius && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	prefix, suffix := "...", "..."
+	if start == 0 {
+		prefix = ""
+	}
+	if end == len(text) {
+		suffix = ""
+	}
+	s := strings.Join(strings.Fields(text[start:end]), " ")
+	return prefix + s + suffix
+}
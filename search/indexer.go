@@ -0,0 +1,122 @@
+package search
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// 单个文件最多索引的文本字节数
+const MaxTextSize = 1 << 20
+
+// 按扩展名直接视为文本的文件类型
+var textExts = map[string]bool{
+	"txt": true, "md": true, "markdown": true, "log": true, "csv": true, "tsv": true,
+	"json": true, "xml": true, "yaml": true, "yml": true, "toml": true, "ini": true, "conf": true,
+	"html": true, "htm": true, "css": true, "sql": true, "sh": true, "bat": true,
+	"go": true, "c": true, "h": true, "cpp": true, "hpp": true, "java": true, "py": true,
+	"js": true, "ts": true, "rs": true, "rb": true, "php": true, "kt": true, "swift": true,
+}
+
+// 从文件内容中提取可索引的文本,非文本文件返回false
+func Extract(name string, data []byte) (string, bool) {
+	if len(data) > MaxTextSize {
+		data = data[:MaxTextSize]
+		// 避免截断在多字节字符中间
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", false
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if !textExts[ext] && !utf8.Valid(data) {
+		return "", false
+	}
+	return strings.ToValidUTF8(string(data), ""), true
+}
+
+type job struct {
+	path   string
+	open   func() (io.ReadCloser, error)
+	remove bool
+}
+
+// 异步索引流水线,按提交顺序依次处理文件的添加与删除
+type Indexer struct {
+	index *Index
+	jobs  chan job
+}
+
+// 创建索引流水线并启动后台任务
+func NewIndexer() *Indexer {
+	ix := &Indexer{index: NewIndex(), jobs: make(chan job, 1024)}
+	go ix.run()
+	return ix
+}
+
+// 提交需要(重新)索引的文件,open用于读取文件内容
+//
+// 不阻塞,调用者可以持有锁;队列满时丢弃并记录日志,文件重新上传或服务重启后会再次索引
+func (ix *Indexer) Add(path string, open func() (io.ReadCloser, error)) {
+	ix.submit(job{path: path, open: open})
+}
+
+// 提交需要(重新)索引的文件,队列满时等待,用于不持有锁时的批量索引
+func (ix *Indexer) AddWait(path string, open func() (io.ReadCloser, error)) {
+	ix.jobs <- job{path: path, open: open}
+}
+
+// 提交需要移除索引的文件,不阻塞;队列满时丢弃,搜索时会过滤掉无权访问的文件
+func (ix *Indexer) Remove(path string) {
+	ix.submit(job{path: path, remove: true})
+}
+
+func (ix *Indexer) submit(j job) {
+	select {
+	case ix.jobs <- j:
+	default:
+		slog.Warn("index queue full, job dropped", "path", j.path, "remove", j.remove)
+	}
+}
+
+func (ix *Indexer) Index() *Index {
+	return ix.index
+}
+
+func (ix *Indexer) run() {
+	for j := range ix.jobs {
+		if j.remove {
+			ix.index.Remove(j.path)
+			continue
+		}
+		text, ok, err := extract(j)
+		if err != nil {
+			slog.Warn("index file failed", "path", j.path, "err", err)
+			continue
+		}
+		if ok {
+			ix.index.Add(j.path, text)
+		} else {
+			ix.index.Remove(j.path)
+		}
+	}
+}
+
+func extract(j job) (string, bool, error) {
+	r, err := j.open()
+	if err != nil {
+		return "", false, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, MaxTextSize+utf8.UTFMax))
+	if err != nil {
+		return "", false, err
+	}
+	text, ok := Extract(j.path, data)
+	return text, ok, nil
+}
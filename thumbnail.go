@@ -0,0 +1,63 @@
+package main
+
+import (
+	"file"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 等待生成缩略图的文件,队列满时丢弃,访问缩略图时再按需生成
+var thumbQueue = make(chan file.File, 1024)
+
+// 在后台依次为上传的图片生成缩略图
+func startThumbnailer() {
+	go func() {
+		for f := range thumbQueue {
+			if err := file.GenerateThumbnails(f); err != nil {
+				slog.Warn("generate thumbnails failed", "path", f.GetPath(), "err", err)
+			}
+		}
+	}()
+}
+
+// 将图片文件加入缩略图生成队列
+func queueThumbnails(f *file.File) {
+	if !file.Thumbnailable(f) {
+		return
+	}
+	select {
+	case thumbQueue <- *f:
+	default:
+	}
+}
+
+// 获取图片文件的缩略图
+//
+// URL:/file/thumbnail?user_id=用户名&path=文件路径&size=small|medium|large,size默认为medium
+//
+// 输出:JPEG格式的缩略图,权限与下载文件相同
+func FileThumbnailHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		annotate(ctx, "user_id", ctx.Query("user_id"), "path", ctx.Query("path"))
+		size := ctx.DefaultQuery("size", "medium")
+		// 复制文件信息后释放锁,生成缩略图时不持有fileLock
+		fileLock.Lock()
+		f := fileMap[ctx.Query("path")]
+		var cp file.File
+		if f != nil {
+			cp = *f
+		}
+		fileLock.Unlock()
+		if f == nil {
+			fail(ctx, file.ErrFileNotExist)
+			return
+		}
+
+		ctl := &file.FileController{}
+		ctl.SetSrv(file.FileServiceImpl{})
+		if err := ctl.Thumbnail(&cp, ctx.Query("user_id"), size, ctx); err != nil {
+			fail(ctx, err)
+		}
+	}
+}
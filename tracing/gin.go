@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 返回trace id的响应头
+const TraceIDHeader = "X-Trace-ID"
+
+// 为每个请求创建服务端span,并从请求头中继承上游的trace
+//
+// span名称为方法与路由,状态码不低于500时标记为错误;有效的trace id通过X-Trace-ID响应头返回
+func Gin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c := otel.GetTextMapPropagator().Extract(ctx.Request.Context(), propagation.HeaderCarrier(ctx.Request.Header))
+		route := ctx.FullPath()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		c, span := tracer().Start(c, ctx.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(ctx.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(ctx.Request.URL.Path),
+				semconv.ClientAddress(ctx.ClientIP()),
+			))
+		defer span.End()
+		ctx.Request = ctx.Request.WithContext(c)
+		if id := TraceID(c); len(id) > 0 {
+			ctx.Header(TraceIDHeader, id)
+		}
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCode(status), semconv.HTTPResponseBodySize(ctx.Writer.Size()))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// 服务名,同时作为Tracer的名称
+const ServiceName = "netdisk"
+
+// 导出方式
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// 设置全局TracerProvider与W3C Trace Context传播
+//
+// exporter为none时不导出span;otlp通过HTTP发送到endpoint(如localhost:4318);stdout输出到标准输出。
+// ratio为采样比例,上游已采样的请求总是采样。返回的函数在退出前调用,发送缓冲中的span
+func Setup(exporter, endpoint string, ratio float64, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(ServiceName), semconv.ServiceVersion(version)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(ServiceName)
+}
+
+// 以ctx中的span为父span开始新的span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// 以数据库会话所属请求的span为父span开始新的span,返回的会话中的数据库操作属于新span
+func StartDB(db *gorm.DB, name string, attrs ...attribute.KeyValue) (*gorm.DB, trace.Span) {
+	if db == nil {
+		_, span := Start(context.Background(), name, attrs...)
+		return nil, span
+	}
+	ctx := context.Background()
+	if db.Statement != nil && db.Statement.Context != nil {
+		ctx = db.Statement.Context
+	}
+	ctx, span := Start(ctx, name, attrs...)
+	return db.WithContext(ctx), span
+}
+
+// 以请求的span为父span开始新的span,调用返回的函数前请求的context指向新span
+func StartGin(ctx *gin.Context, name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	req := ctx.Request
+	c, span := Start(req.Context(), name, attrs...)
+	ctx.Request = req.WithContext(c)
+	return span, func() { ctx.Request = req }
+}
+
+// 结束span,err不为nil时记录错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 返回ctx中span的trace id,没有有效的span时返回空字符串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// 返回ctx中span的span id,没有有效的span时返回空字符串
+func SpanID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.SpanID().String()
+}
+
+// span中的用户id
+func UserID(id string) attribute.KeyValue {
+	return attribute.String("netdisk.user_id", id)
+}
+
+// span中的文件路径
+func Path(path string) attribute.KeyValue {
+	return attribute.String("netdisk.path", path)
+}
@@ -0,0 +1,38 @@
+package user
+
+import "errors"
+
+// 错误类型,调用者通过errors.Is判断,HTTP层据此返回状态码与错误码
+var (
+	// 参数不合法
+	ErrInvalid = errors.New("invalid argument")
+	// 用户不存在
+	ErrUserNotExist = errors.New("user not exist")
+	// 用户已存在
+	ErrUserExisted = errors.New("user already exist")
+	// 用户名或密码错误
+	ErrInvalidCredentials = errors.New("invalid user id or password")
+	// 已经是好友
+	ErrFriendExisted = errors.New("friend already exist")
+	// 好友数量达到上限
+	ErrFriendLimit = errors.New("friend limit exceed")
+)
+
+// 带类型的错误,Error返回具体的错误信息,errors.Is可以判断其类型
+type Error struct {
+	kind error
+	msg  string
+}
+
+// 参数不合法的错误
+func invalid(msg string) *Error {
+	return &Error{kind: ErrInvalid, msg: msg}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
@@ -1,6 +1,6 @@
 module user
 
-go 1.18
+go 1.21
 
 require gorm.io/gorm v1.24.3
 
@@ -3,18 +3,20 @@ package user
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	Id       string `gorm:"column:user_id"`
-	Password string `gorm:"column:password"`
-	Friends  string `gorm:"column:friends"`
-	Filenum  int    `gorm:"column:file_num"`
-	Diskused int64  `gorm:"column:disk_len"`
-	Disk     int64  `gorm:"column:disk_cap"`
+	Id        string     `gorm:"column:user_id;size:64;index"`
+	Password  string     `gorm:"column:password"`
+	Friends   string     `gorm:"column:friends"`
+	Filenum   int        `gorm:"column:file_num"`
+	Diskused  int64      `gorm:"column:disk_len"`
+	Disk      int64      `gorm:"column:disk_cap"`
+	LastLogin *time.Time `gorm:"column:last_login"`
 }
 
 func (u *User) String() string {
@@ -55,8 +57,7 @@ func (u *User) GetFriends() []string {
 
 func (u *User) SetFriends(friends string, db *gorm.DB) error {
 	u.Friends = friends
-	db.Model(u).Update("friends", u.Friends)
-	return db.Error
+	return db.Model(u).Update("friends", u.Friends).Error
 }
 
 func (u *User) GetFilenum() int {
@@ -83,6 +84,11 @@ func (u *User) SetUseddisk(udisk int64) bool {
 	return true
 }
 
+// 将文件数与已用空间写入数据库,零值同样会被写入
+func (u *User) SaveUsage(db *gorm.DB) error {
+	return db.Model(u).Select("file_num", "disk_len").Updates(u).Error
+}
+
 func (u *User) GetDisk() int64 {
 	return u.Disk
 }
@@ -91,3 +97,12 @@ func (u *User) SetDisk(disk int64) bool {
 	u.Disk = disk
 	return true
 }
+
+func (u *User) GetLastLogin() *time.Time {
+	return u.LastLogin
+}
+
+func (u *User) SetLastLogin(t time.Time, db *gorm.DB) error {
+	u.LastLogin = &t
+	return db.Model(u).Update("last_login", t).Error
+}
@@ -1,7 +1,12 @@
 package user
 
-import "gorm.io/gorm"
+import (
+	"tracing"
 
+	"gorm.io/gorm"
+)
+
+// 访问数据库的方法在请求的span下创建span,服务层与数据库操作的span是它的子span
 type UserController struct {
 	userservice IUserService
 }
@@ -14,10 +19,24 @@ func (c *UserController) Update(u *User, info map[string]string) error {
 	return c.userservice.Update(u, info)
 }
 
-func (c *UserController) UpdateFriends(u *User, friendid string, db *gorm.DB) error {
+func (c *UserController) UpdateFriends(u *User, friendid string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "UserController.UpdateFriends", tracing.UserID(u.GetId()))
+	defer func() { tracing.End(span, err) }()
 	return c.userservice.UpdateFriends(u, friendid, db)
 }
 
 func (c *UserController) GetFriends(u *User) ([]string, error) {
 	return c.userservice.GetFriends(u)
 }
+
+func (c *UserController) Query(q UserQuery, db *gorm.DB) (res []User, total int64, err error) {
+	db, span := tracing.StartDB(db, "UserController.Query")
+	defer func() { tracing.End(span, err) }()
+	return c.userservice.Query(q, db)
+}
+
+func (c *UserController) Stats(top int, db *gorm.DB) (res UserStats, err error) {
+	db, span := tracing.StartDB(db, "UserController.Stats")
+	defer func() { tracing.End(span, err) }()
+	return c.userservice.Stats(top, db)
+}
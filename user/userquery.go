@@ -0,0 +1,104 @@
+package user
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 管理员查询用户的条件,nil或零值表示不限制
+type UserQuery struct {
+	// 用户名包含的子串
+	IDContains string
+	// 已用空间范围
+	MinUsed *int64
+	MaxUsed *int64
+	// 总空间范围
+	MinDisk *int64
+	MaxDisk *int64
+	// 文件数量范围
+	MinFiles *int
+	MaxFiles *int
+	// 注册时间范围
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// 最后登录时间范围
+	LoginAfter  *time.Time
+	LoginBefore *time.Time
+	// 排序字段:id/used/disk/files/created/last_login
+	Sort string
+	Desc bool
+	// 分页
+	Offset int
+	Limit  int
+}
+
+// 排序字段对应的数据库列
+var sortColumns = map[string]string{
+	"":           "user_id",
+	"id":         "user_id",
+	"used":       "disk_len",
+	"disk":       "disk_cap",
+	"files":      "file_num",
+	"created":    "created_at",
+	"last_login": "last_login",
+}
+
+// 按条件查询用户,返回当前页的用户与符合条件的总数
+func queryUsers(q UserQuery, db *gorm.DB) ([]User, int64, error) {
+	column, ok := sortColumns[q.Sort]
+	if !ok {
+		return nil, 0, invalid("invalid sort")
+	}
+
+	tx := db.Model(&User{})
+	if len(q.IDContains) > 0 {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q.IDContains)
+		tx = tx.Where("user_id LIKE ?", "%"+escaped+"%")
+	}
+	if q.MinUsed != nil {
+		tx = tx.Where("disk_len >= ?", *q.MinUsed)
+	}
+	if q.MaxUsed != nil {
+		tx = tx.Where("disk_len <= ?", *q.MaxUsed)
+	}
+	if q.MinDisk != nil {
+		tx = tx.Where("disk_cap >= ?", *q.MinDisk)
+	}
+	if q.MaxDisk != nil {
+		tx = tx.Where("disk_cap <= ?", *q.MaxDisk)
+	}
+	if q.MinFiles != nil {
+		tx = tx.Where("file_num >= ?", *q.MinFiles)
+	}
+	if q.MaxFiles != nil {
+		tx = tx.Where("file_num <= ?", *q.MaxFiles)
+	}
+	if q.CreatedAfter != nil {
+		tx = tx.Where("created_at >= ?", *q.CreatedAfter)
+	}
+	if q.CreatedBefore != nil {
+		tx = tx.Where("created_at < ?", *q.CreatedBefore)
+	}
+	if q.LoginAfter != nil {
+		tx = tx.Where("last_login >= ?", *q.LoginAfter)
+	}
+	if q.LoginBefore != nil {
+		tx = tx.Where("last_login < ?", *q.LoginBefore)
+	}
+
+	// 复用同一查询条件统计总数与分页
+	tx = tx.Session(&gorm.Session{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	order := column
+	if q.Desc {
+		order += " DESC"
+	}
+	var users []User
+	err := tx.Order(order).Order("id").Offset(q.Offset).Limit(q.Limit).Find(&users).Error
+	return users, total, err
+}
@@ -1,9 +1,9 @@
 package user
 
 import (
-	"errors"
 	"strconv"
 	"strings"
+	"tracing"
 
 	"gorm.io/gorm"
 )
@@ -15,6 +15,10 @@ type IUserService interface {
 	UpdateFriends(*User, string, *gorm.DB) error
 	// 获取好友列表
 	GetFriends(*User) ([]string, error)
+	// 管理员按条件查询用户
+	Query(UserQuery, *gorm.DB) ([]User, int64, error)
+	// 用户使用情况统计
+	Stats(int, *gorm.DB) (UserStats, error)
 }
 
 type UserServiceImpl struct {
@@ -23,7 +27,7 @@ type UserServiceImpl struct {
 // 更新密码或可用磁盘大小
 func (srv UserServiceImpl) Update(u *User, info map[string]string) error {
 	if u == nil {
-		return errors.New("user not exist")
+		return ErrUserNotExist
 	}
 
 	// 更新密码
@@ -40,7 +44,7 @@ func (srv UserServiceImpl) Update(u *User, info map[string]string) error {
 
 		// 防止更新后总空间大小小于已用空间
 		if disk < u.GetUseddisk() {
-			return errors.New("new disk space too small")
+			return invalid("new disk space too small")
 		}
 		u.SetDisk(disk)
 	}
@@ -48,18 +52,20 @@ func (srv UserServiceImpl) Update(u *User, info map[string]string) error {
 }
 
 // 添加好友,上限为10个
-func (srv UserServiceImpl) UpdateFriends(u *User, friendid string, db *gorm.DB) error {
+func (srv UserServiceImpl) UpdateFriends(u *User, friendid string, db *gorm.DB) (err error) {
+	db, span := tracing.StartDB(db, "UserService.UpdateFriends")
+	defer func() { tracing.End(span, err) }()
 	if friendid == u.Id {
-		return errors.New("can't be your own friend")
+		return invalid("can't be your own friend")
 	}
 	friends := u.GetFriends()
 	for _, v := range friends {
 		if friendid == v {
-			return errors.New("friend already exist")
+			return ErrFriendExisted
 		}
 	}
 	if len(friends) >= 10 {
-		return errors.New("friend limit exceed")
+		return ErrFriendLimit
 	}
 	friends = append(friends, friendid)
 	return u.SetFriends(strings.Join(friends, ","), db)
@@ -70,3 +76,20 @@ func (srv UserServiceImpl) GetFriends(u *User) ([]string, error) {
 	res := u.GetFriends()
 	return res, nil
 }
+
+// 按条件查询用户,返回当前页的用户与总数
+func (srv UserServiceImpl) Query(q UserQuery, db *gorm.DB) (res []User, total int64, err error) {
+	db, span := tracing.StartDB(db, "UserService.Query")
+	defer func() { tracing.End(span, err) }()
+	if q.Offset < 0 || q.Limit <= 0 {
+		return nil, 0, invalid("invalid page")
+	}
+	return queryUsers(q, db)
+}
+
+// 统计用户数量与空间使用情况
+func (srv UserServiceImpl) Stats(top int, db *gorm.DB) (res UserStats, err error) {
+	db, span := tracing.StartDB(db, "UserService.Stats")
+	defer func() { tracing.End(span, err) }()
+	return collectStats(top, db)
+}
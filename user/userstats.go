@@ -0,0 +1,24 @@
+package user
+
+import "gorm.io/gorm"
+
+// 用户相关的汇总统计
+type UserStats struct {
+	Users     int64
+	Allocated int64
+	Used      int64
+	// 已用空间最多的用户
+	Top []User
+}
+
+// 统计用户数、分配与已用空间,以及已用空间最多的top个用户
+func collectStats(top int, db *gorm.DB) (UserStats, error) {
+	var stats UserStats
+	err := db.Model(&User{}).Select("COUNT(*), COALESCE(SUM(disk_cap), 0), COALESCE(SUM(disk_len), 0)").
+		Row().Scan(&stats.Users, &stats.Allocated, &stats.Used)
+	if err != nil {
+		return stats, err
+	}
+	err = db.Order("disk_len DESC").Order("id").Limit(top).Find(&stats.Top).Error
+	return stats, err
+}
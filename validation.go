@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"file"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// JSON请求体的大小上限
+const maxJSONBody = 1 << 20
+
+// 请求体超过大小上限
+var errBodyTooLarge = errors.New("request body too large")
+
+// 上传请求没有Content-Length
+var errLengthRequired = errors.New("content length required")
+
+// 用户名:3到32位字母、数字、下划线或连字符
+var userIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	// 字段错误中使用Json字段名
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("userid", func(fl validator.FieldLevel) bool {
+		return userIDPattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return strongPassword(fl.Field().String())
+	})
+	v.RegisterValidation("filename", func(fl validator.FieldLevel) bool {
+		_, err := file.CleanName(fl.Field().String())
+		return err == nil
+	})
+	v.RegisterValidation("filepath", func(fl validator.FieldLevel) bool {
+		return validPath(fl.Field().String())
+	})
+}
+
+// 密码为8到64位,至少包含一个字母和一个数字
+func strongPassword(s string) bool {
+	if len(s) < 8 || len(s) > 64 {
+		return false
+	}
+	var letter, digit bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	return letter && digit
+}
+
+// 文件路径为"用户名/文件名",文件名已经规范化
+func validPath(p string) bool {
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return false
+	}
+	name, err := file.CleanName(parts[1])
+	return err == nil && name == parts[1]
+}
+
+// 单个字段的校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// 请求体的字段校验错误,Error返回所有字段的错误
+type validationError []FieldError
+
+func (e validationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e validationError) Unwrap() error {
+	return errInvalidParam
+}
+
+// 校验规则对应的错误信息
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "userid":
+		return "must be 3-32 letters, digits, '_' or '-'"
+	case "password":
+		return "must be 8-64 characters with at least one letter and one digit"
+	case "filename":
+		return "must be a relative file name without '..'"
+	case "filepath":
+		return "must be a file path like user/name"
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	case "min", "max":
+		unit := ""
+		if fe.Kind() == reflect.String {
+			unit = " characters"
+		} else if fe.Kind() == reflect.Slice {
+			unit = " items"
+		}
+		if fe.Tag() == "min" {
+			return "must have at least " + fe.Param() + unit
+		}
+		return "must have at most " + fe.Param() + unit
+	}
+	return "is invalid"
+}
+
+// 读取并校验Json请求体
+//
+// 请求体超过maxJSONBody时返回errBodyTooLarge;空请求体视为空对象;
+// Json格式错误或字段类型不符时返回参数错误;校验失败时返回包含每个字段错误的validationError
+func bindJSON(ctx *gin.Context, obj any) error {
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxJSONBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errBodyTooLarge
+		}
+		return fmt.Errorf("%w when reading request body", err)
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = json.Unmarshal(body, obj); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return validationError{{Field: typeErr.Field, Rule: "type", Message: "must be " + typeErr.Type.String()}}
+			}
+			return invalidParam("invalid json: %v", err)
+		}
+	}
+	err = binding.Validator.ValidateStruct(obj)
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	res := make(validationError, len(errs))
+	for i, fe := range errs {
+		// 去掉最外层的结构体名
+		field := fe.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		res[i] = FieldError{Field: field, Rule: fe.Tag(), Message: ruleMessage(fe)}
+	}
+	return res
+}
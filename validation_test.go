@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用body构造请求并绑定到新的obj
+func bindBody(body string, obj any) error {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	return bindJSON(ctx, obj)
+}
+
+func TestBindJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		obj  any
+		// 期望的字段错误,"字段:规则"
+		fields []string
+		err    error
+	}{
+		{"valid register", `{"user_id":"alice_1","password":"secret123","disk":1024}`, &RegisterMsg{}, nil, nil},
+		{"missing fields", `{}`, &RegisterMsg{}, []string{"user_id:required", "password:required"}, nil},
+		{"empty body", ``, &RawUser{}, []string{"user_id:required", "password:required"}, nil},
+		{"short user id", `{"user_id":"al","password":"secret123"}`, &RegisterMsg{}, []string{"user_id:userid"}, nil},
+		{"user id with slash", `{"user_id":"al/ice","password":"secret123"}`, &RegisterMsg{}, []string{"user_id:userid"}, nil},
+		{"weak password", `{"user_id":"alice","password":"onlyletters"}`, &RegisterMsg{}, []string{"password:password"}, nil},
+		{"negative disk", `{"user_id":"alice","password":"secret123","disk":-1}`, &RegisterMsg{}, []string{"disk:gte"}, nil},
+		{"wrong type", `{"user_id":"alice","password":"secret123","disk":"big"}`, &RegisterMsg{}, []string{"disk:type"}, nil},
+		{"traversal path", `{"user_id":"alice","path":"alice/../bob/a.txt"}`, &CopyMsg{}, []string{"path:filepath"}, nil},
+		{"path without user", `{"user_id":"alice","path":"a.txt"}`, &CopyMsg{}, []string{"path:filepath"}, nil},
+		{"absolute dest", `{"user_id":"alice","path":"alice/a.txt","dest":"/etc/passwd"}`, &CopyMsg{}, []string{"dest:filename"}, nil},
+		{"nested path", `{"user_id":"alice","paths":["alice/a.txt","alice/.."]}`, &ArchiveMsg{}, []string{"paths[1]:filepath"}, nil},
+		{"invalid format", `{"user_id":"alice","format":"rar"}`, &ArchiveMsg{}, []string{"format:oneof"}, nil},
+		{"batch op", `{"user_id":"alice","ops":[{"op":"rename","path":"alice/a.txt"}]}`, &BatchMsg{}, []string{"ops[0].op:oneof"}, nil},
+		{"malformed json", `{"user_id":`, &RawUser{}, nil, errInvalidParam},
+		{"too large", `{"user_id":"` + strings.Repeat("a", maxJSONBody) + `"}`, &RawUser{}, nil, errBodyTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := bindBody(c.body, c.obj)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("err = %v, want %v", err, c.err)
+				}
+				return
+			}
+			if len(c.fields) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var fields validationError
+			if !errors.As(err, &fields) {
+				t.Fatalf("err = %v, want field errors", err)
+			}
+			if !errors.Is(err, errInvalidParam) {
+				t.Fatal("field errors are not invalid parameters")
+			}
+			got := make([]string, len(fields))
+			for i, f := range fields {
+				got[i] = f.Field + ":" + f.Rule
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.fields) {
+				t.Fatalf("fields = %v, want %v", got, c.fields)
+			}
+		})
+	}
+}